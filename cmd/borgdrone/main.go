@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"codeberg.org/jstover/borgdrone/internal/borg"
 	"codeberg.org/jstover/borgdrone/internal/cmdargs"
 	"codeberg.org/jstover/borgdrone/internal/config"
 )
@@ -13,9 +18,14 @@ func main() {
 
 	cfg, err := config.ReadConfigFile(args.ConfigFile)
 	if err != nil {
-		log.Fatal(err)
+		if !args.ReadsConfigItself() {
+			log.Fatal(err)
+		}
+		cfg = config.Config{Path: args.ConfigFile}
 	}
 
-	args.RunSubcommand(cfg)
-
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := args.RunSubcommand(ctx, borg.Runner{}, cfg)
+	stop()
+	os.Exit(code)
 }
@@ -1,68 +1,143 @@
 package borg
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
 
 	"codeberg.org/jstover/borgdrone/internal/logger"
-	"github.com/go-cmd/cmd"
+	"codeberg.org/jstover/borgdrone/internal/stream"
 )
 
-func assertExists() {
-	_, err := exec.LookPath("borg")
-	if err != nil {
-		logger.Fatal("borg command was not found or is not installed.", 1)
-	}
-}
+// stderr receives the stderr of borg as it is produced, so progress and errors remain visible
+var stderr io.Writer = os.Stderr
+
+// ErrBorgNotFound is returned when the borg executable cannot be found in $PATH
+var ErrBorgNotFound = errors.New("borg command was not found or is not installed")
 
-type Runner struct {
-	Env    []string
-	Stdout []string
+// Borg exit codes. Any other non-zero exit code is also an error
+const (
+	ExitCodeSuccess = 0
+	ExitCodeWarning = 1
+	ExitCodeError   = 2
+)
+
+// ExitError is returned when borg exits with a non-zero status
+type ExitError struct {
+	Code   int
+	Args   []string
 	Stderr []string
 }
 
-func (r *Runner) Run(args ...string) bool {
-	assertExists()
-	cmdOptions := cmd.Options{
-		Buffered:  false,
-		Streaming: true,
+func (e *ExitError) Error() string {
+	kind := "failed"
+	if e.IsWarning() {
+		kind = "completed with warnings"
+	}
+	name := ""
+	if len(e.Args) > 0 {
+		name = " " + e.Args[0]
+	}
+	msg := fmt.Sprintf("borg%s %s (exit code %d)", name, kind, e.Code)
+	if len(e.Stderr) > 0 {
+		msg += ": " + e.Stderr[len(e.Stderr)-1]
 	}
-	command := cmd.NewCmdOptions(cmdOptions, "borg", args...)
-	command.Env = r.Env
-
-	doneChan := make(chan struct{})
-	go func() {
-		defer close(doneChan)
-		for command.Stdout != nil || command.Stderr != nil {
-			select {
-			case line, open := <-command.Stdout:
-				if !open {
-					command.Stdout = nil
-					continue
-				}
-				logger.Debug(line)
-				r.Stdout = append(r.Stdout, line)
-			case line, open := <-command.Stderr:
-				if !open {
-					command.Stderr = nil
-					continue
-				}
-				logger.Debug(line)
-				fmt.Fprintln(os.Stderr, line)
-				r.Stderr = append(r.Stderr, line)
-			}
+	return msg
+}
+
+// IsWarning returns true if borg completed but reported warnings, such as files which changed while being read
+func (e *ExitError) IsWarning() bool {
+	return e.Code == ExitCodeWarning
+}
+
+// IsWarning returns true if err is an *ExitError for a borg warning
+func IsWarning(err error) bool {
+	var exitErr *ExitError
+	return errors.As(err, &exitErr) && exitErr.IsWarning()
+}
+
+// Result holds the output of a completed borg command
+type Result struct {
+	ExitCode int
+	Stdout   []string
+	Stderr   []string
+}
+
+// Options configure a single borg invocation
+type Options struct {
+	// Env is set for borg on top of the environment of borgdrone, see Environment
+	Env []string
+	Dir string
+	// Stdin is read by borg, for interactive commands such as key import --paper. borg reads nothing if it is nil
+	Stdin io.Reader
+	// Quiet disables logging of borg's output, for commands such as --json where the output is parsed instead.
+	// stderr is still written to stderr, so stdout is left for the parsed document
+	Quiet bool
+	// LogPrefix is prepended to each line of borg output
+	LogPrefix string
+}
+
+// Executor runs borg commands. Runner executes the real borg binary,
+// while borgtest.Executor records invocations and replays canned output for tests
+type Executor interface {
+	Run(ctx context.Context, opts Options, args ...string) (*Result, error)
+}
+
+// Environment returns the environment of borgdrone with env added. Passphrase commands run by borg need
+// variables such as PATH, HOME, GNUPGHOME and DBUS_SESSION_BUS_ADDRESS, but inherited BORG_* variables are dropped
+// so a BORG_PASSPHRASE or BORG_REPO from the user's shell cannot take precedence over the target configuration
+func Environment(env []string) []string {
+	inherited := []string{}
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, "BORG_") {
+			inherited = append(inherited, v)
 		}
-	}()
+	}
+	return append(inherited, env...)
+}
 
-	<-command.Start()
-	<-doneChan
+// Runner is the Executor which runs the borg binary found in $PATH
+type Runner struct{}
 
-	status := command.Status()
-	if status.Error != nil {
-		logger.Fatal(status.Error.Error(), 2)
+// Run executes borg with the provided arguments, streaming its output as it is produced.
+// A *Result holding any output read so far is returned whenever borg was found, including alongside an *ExitError
+func (r Runner) Run(ctx context.Context, opts Options, args ...string) (*Result, error) {
+	if _, err := exec.LookPath("borg"); err != nil {
+		return nil, ErrBorgNotFound
 	}
+	command := stream.NewCmd("borg", args...)
+	command.Env = Environment(opts.Env)
+	command.Dir = opts.Dir
 
-	return status.Exit == 0
+	result := &Result{}
+	onStdout := func(line string) {
+		if !opts.Quiet {
+			logger.Debug("%s", logger.PrefixLine(opts.LogPrefix, line))
+		}
+		result.Stdout = append(result.Stdout, line)
+	}
+	onStderr := func(line string) {
+		if !opts.Quiet {
+			logger.Debug("%s", logger.PrefixLine(opts.LogPrefix, line))
+		}
+		fmt.Fprintln(stderr, logger.PrefixLine(opts.LogPrefix, line))
+		result.Stderr = append(result.Stderr, line)
+	}
+	status, err := stream.Run(ctx, command, opts.Stdin, onStdout, onStderr)
+	if err != nil {
+		return result, err
+	}
+	if status.Error != nil {
+		return result, status.Error
+	}
 
+	result.ExitCode = status.Exit
+	if status.Exit != ExitCodeSuccess {
+		return result, &ExitError{Code: status.Exit, Args: args, Stderr: result.Stderr}
+	}
+	return result, nil
 }
@@ -0,0 +1,101 @@
+package borg
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeBorg installs a borg script running body at the front of $PATH
+func fakeBorg(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "borg"), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunnerLogPrefix(t *testing.T) {
+	fakeBorg(t, "echo 'archive created'; echo 'Use% 50%' >&2")
+	var buf bytes.Buffer
+	stderr = &buf
+	t.Cleanup(func() { stderr = os.Stderr })
+
+	result, err := Runner{}.Run(context.Background(), Options{LogPrefix: "laptop:usb"}, "create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"archive created"}; !slices.Equal(result.Stdout, want) {
+		t.Errorf("stdout = %q, want %q", result.Stdout, want)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "[laptop:usb] Use% 50%" {
+		t.Errorf("stderr = %q, want the target prefix", got)
+	}
+}
+
+func TestRunnerEnvironment(t *testing.T) {
+	// Mimic borg running its passphrase command, which depends on a variable inherited from borgdrone
+	fakeBorg(t, `eval "$BORG_PASSCOMMAND"; echo "${BORG_PASSPHRASE:-unset}"`)
+	t.Setenv("BORGDRONE_TEST_SECRET_DIR", t.TempDir())
+	if err := os.WriteFile(filepath.Join(os.Getenv("BORGDRONE_TEST_SECRET_DIR"), "secret"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BORG_PASSPHRASE", "from the shell")
+
+	opts := Options{Env: []string{`BORG_PASSCOMMAND=sh -c 'cat "$BORGDRONE_TEST_SECRET_DIR/secret"'`}, Quiet: true}
+	result, err := Runner{}.Run(context.Background(), opts, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s3cret", "unset"}; !slices.Equal(result.Stdout, want) {
+		t.Errorf("stdout = %q, want %q", result.Stdout, want)
+	}
+}
+
+func TestRunnerQuiet(t *testing.T) {
+	fakeBorg(t, `echo '{"archives": []}'; echo 'Remote: some warning' >&2`)
+	var buf bytes.Buffer
+	stderr = &buf
+	t.Cleanup(func() { stderr = os.Stderr })
+
+	// Capture stdout, which must only contain what the caller prints from the parsed output
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, runErr := Runner{}.Run(context.Background(), Options{Quiet: true}, "list", "--json")
+	os.Stdout = stdout
+	w.Close()
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if runErr != nil {
+		t.Fatal(runErr)
+	}
+	if out.Len() != 0 {
+		t.Errorf("stdout = %q, want nothing", out.String())
+	}
+	if got := strings.TrimSpace(buf.String()); got != "Remote: some warning" {
+		t.Errorf("stderr = %q, want the borg warning", got)
+	}
+}
+
+func TestRunnerStartFailureReturnsResult(t *testing.T) {
+	fakeBorg(t, "echo unreachable")
+	result, err := Runner{}.Run(context.Background(), Options{Dir: filepath.Join(t.TempDir(), "missing"), Quiet: true}, "extract")
+	if err == nil {
+		t.Fatal("expected an error for a missing working directory")
+	}
+	if result == nil {
+		t.Error("result is nil")
+	}
+}
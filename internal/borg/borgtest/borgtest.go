@@ -0,0 +1,85 @@
+// Package borgtest provides a fake borg.Executor for testing code which runs borg
+package borgtest
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+)
+
+// Call records a single invocation of the fake Executor
+type Call struct {
+	Args  []string
+	Env   []string
+	Dir   string
+	Stdin io.Reader
+}
+
+// Response is the canned output replayed for an invocation.
+// A non-zero ExitCode is returned as a *borg.ExitError, and Err (if set) is returned instead of any result
+type Response struct {
+	Stdout   []string
+	Stderr   []string
+	ExitCode int
+	Err      error
+}
+
+// Executor is a borg.Executor which records every call and replays canned responses.
+// Responses are keyed by borg subcommand (the first argument), and are consumed in order.
+// Once the responses for a subcommand are exhausted, a successful empty response is returned
+type Executor struct {
+	mu        sync.Mutex
+	Calls     []Call
+	Responses map[string][]Response
+}
+
+// New returns an Executor with no canned responses
+func New() *Executor {
+	return &Executor{Responses: make(map[string][]Response)}
+}
+
+// Respond queues a response for the next invocation of the borg subcommand
+func (e *Executor) Respond(subcommand string, r Response) *Executor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Responses[subcommand] = append(e.Responses[subcommand], r)
+	return e
+}
+
+// Run records the call and returns the next canned response for the subcommand
+func (e *Executor) Run(ctx context.Context, opts borg.Options, args ...string) (*borg.Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Calls = append(e.Calls, Call{Args: slices.Clone(args), Env: slices.Clone(opts.Env), Dir: opts.Dir, Stdin: opts.Stdin})
+
+	var r Response
+	if len(args) > 0 && len(e.Responses[args[0]]) > 0 {
+		r = e.Responses[args[0]][0]
+		e.Responses[args[0]] = e.Responses[args[0]][1:]
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result := &borg.Result{ExitCode: r.ExitCode, Stdout: r.Stdout, Stderr: r.Stderr}
+	if r.ExitCode != borg.ExitCodeSuccess {
+		return result, &borg.ExitError{Code: r.ExitCode, Args: args, Stderr: r.Stderr}
+	}
+	return result, nil
+}
+
+// Args returns the argument lists of every recorded call, in order
+func (e *Executor) Args() [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	args := [][]string{}
+	for _, c := range e.Calls {
+		args = append(args, c.Args)
+	}
+	return args
+}
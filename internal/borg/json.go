@@ -0,0 +1,106 @@
+package borg
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Repository describes a borg repository in --json output
+type Repository struct {
+	ID           string `json:"id" yaml:"id"`
+	LastModified string `json:"last_modified" yaml:"last_modified"`
+	Location     string `json:"location" yaml:"location"`
+}
+
+// Encryption describes the repository encryption mode in --json output
+type Encryption struct {
+	Mode    string `json:"mode" yaml:"mode"`
+	Keyfile string `json:"keyfile,omitempty" yaml:"keyfile,omitempty"`
+}
+
+// CacheStats holds the repository-wide chunk statistics reported by `borg info --json`
+type CacheStats struct {
+	TotalChunks       int64 `json:"total_chunks" yaml:"total_chunks"`
+	TotalCsize        int64 `json:"total_csize" yaml:"total_csize"`
+	TotalSize         int64 `json:"total_size" yaml:"total_size"`
+	TotalUniqueChunks int64 `json:"total_unique_chunks" yaml:"total_unique_chunks"`
+	UniqueCsize       int64 `json:"unique_csize" yaml:"unique_csize"`
+	UniqueSize        int64 `json:"unique_size" yaml:"unique_size"`
+}
+
+// Cache describes the local borg cache for a repository
+type Cache struct {
+	Path  string     `json:"path" yaml:"path"`
+	Stats CacheStats `json:"stats" yaml:"stats"`
+}
+
+// ArchiveStats holds the size statistics of a single archive
+type ArchiveStats struct {
+	CompressedSize   int64 `json:"compressed_size" yaml:"compressed_size"`
+	DeduplicatedSize int64 `json:"deduplicated_size" yaml:"deduplicated_size"`
+	NFiles           int64 `json:"nfiles" yaml:"nfiles"`
+	OriginalSize     int64 `json:"original_size" yaml:"original_size"`
+}
+
+// ArchiveSummary is a single entry in the output of `borg list --json`
+type ArchiveSummary struct {
+	Name  string `json:"name" yaml:"name"`
+	ID    string `json:"id" yaml:"id"`
+	Start string `json:"start" yaml:"start"`
+	Time  string `json:"time" yaml:"time"`
+}
+
+// ArchiveInfo is a detailed archive entry, as printed by `borg info --json` and `borg create --json`
+type ArchiveInfo struct {
+	Name     string       `json:"name" yaml:"name"`
+	ID       string       `json:"id" yaml:"id"`
+	Start    string       `json:"start" yaml:"start"`
+	End      string       `json:"end" yaml:"end"`
+	Duration float64      `json:"duration" yaml:"duration"`
+	Stats    ArchiveStats `json:"stats" yaml:"stats"`
+}
+
+// ListOutput is the document printed by `borg list --json`
+type ListOutput struct {
+	Archives   []ArchiveSummary `json:"archives" yaml:"archives"`
+	Encryption Encryption       `json:"encryption" yaml:"encryption"`
+	Repository Repository       `json:"repository" yaml:"repository"`
+}
+
+// InfoOutput is the document printed by `borg info --json`
+type InfoOutput struct {
+	Archives    []ArchiveInfo `json:"archives,omitempty" yaml:"archives,omitempty"`
+	Cache       Cache         `json:"cache" yaml:"cache"`
+	Encryption  Encryption    `json:"encryption" yaml:"encryption"`
+	Repository  Repository    `json:"repository" yaml:"repository"`
+	SecurityDir string        `json:"security_dir" yaml:"security_dir"`
+}
+
+// CreateOutput is the document printed by `borg create --json`
+type CreateOutput struct {
+	Archive    ArchiveInfo `json:"archive" yaml:"archive"`
+	Cache      Cache       `json:"cache" yaml:"cache"`
+	Encryption Encryption  `json:"encryption" yaml:"encryption"`
+	Repository Repository  `json:"repository" yaml:"repository"`
+}
+
+func parseOutput[T any](stdout []string) (T, error) {
+	var out T
+	err := json.Unmarshal([]byte(strings.Join(stdout, "\n")), &out)
+	return out, err
+}
+
+// ParseListOutput decodes the stdout lines of `borg list --json`
+func ParseListOutput(stdout []string) (ListOutput, error) {
+	return parseOutput[ListOutput](stdout)
+}
+
+// ParseInfoOutput decodes the stdout lines of `borg info --json`
+func ParseInfoOutput(stdout []string) (InfoOutput, error) {
+	return parseOutput[InfoOutput](stdout)
+}
+
+// ParseCreateOutput decodes the stdout lines of `borg create --json`
+func ParseCreateOutput(stdout []string) (CreateOutput, error) {
+	return parseOutput[CreateOutput](stdout)
+}
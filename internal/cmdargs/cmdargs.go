@@ -1,16 +1,23 @@
 package cmdargs
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
+	"time"
 
+	"codeberg.org/jstover/borgdrone/internal/borg"
 	"codeberg.org/jstover/borgdrone/internal/commands"
 	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
 
 	"github.com/alexflint/go-arg"
 )
@@ -19,7 +26,7 @@ import (
 // This allows all subcommands to have a .Run() method with a consistent signature.
 // Subcommand-specific args are passed into the real command function by their respective implementations
 type RunnableCommand interface {
-	Run(cfg config.Config) int
+	Run(ctx context.Context, executor borg.Executor, cfg config.Config) error
 }
 
 // BorgTarget holds the ARCHIVE:REPO target specified as CLI argument
@@ -67,9 +74,8 @@ type ListTargetsCmd struct {
 	Format string `arg:"-F,--format" default:"text"`
 }
 
-func (cmd ListTargetsCmd) Run(cfg config.Config) int {
-	commands.ListTargets(cfg, cmd.Format)
-	return 0
+func (cmd ListTargetsCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	return commands.ListTargets(cfg, cmd.Format)
 }
 
 // initialise
@@ -78,46 +84,233 @@ type InitialiseCmd struct {
 	Target BorgTarget `arg:"required,positional"`
 }
 
-func (cmd InitialiseCmd) Run(cfg config.Config) int {
+func (cmd InitialiseCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
-	commands.Initialise(targets)
-	return 0
+	return commands.Initialise(ctx, executor, targets)
 }
 
 // info
 // ----------------------------------------------------------------------------
 type InfoCmd struct {
 	Target BorgTarget `arg:"required,positional"`
+	Format string     `arg:"-F,--format" default:"text"`
 }
 
-func (cmd InfoCmd) Run(cfg config.Config) int {
+func (cmd InfoCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
-	commands.Info(targets)
-	return 0
+	return commands.Info(ctx, executor, targets, cmd.Format)
 }
 
 // list
 // ----------------------------------------------------------------------------
 type ListCmd struct {
 	Target BorgTarget `arg:"required,positional"`
+	Format string     `arg:"-F,--format" default:"text"`
+	All    bool       `arg:"--all"`
 }
 
-func (cmd ListCmd) Run(cfg config.Config) int {
+func (cmd ListCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
-	commands.List(targets)
-	return 0
+	return commands.List(ctx, executor, targets, cmd.Format, cmd.All)
 }
 
 // create
 // ----------------------------------------------------------------------------
 type CreateCmd struct {
+	Target   BorgTarget `arg:"required,positional"`
+	Parallel int        `arg:"--parallel"`
+}
+
+func (cmd CreateCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.Create(ctx, executor, targets, parallelism(cmd.Parallel, cfg))
+}
+
+// run
+// ----------------------------------------------------------------------------
+type RunCmd struct {
+	Target   BorgTarget `arg:"required,positional"`
+	Parallel int        `arg:"--parallel"`
+}
+
+func (cmd RunCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.Run(ctx, executor, targets, parallelism(cmd.Parallel, cfg))
+}
+
+// parallelism returns the number of targets to process concurrently, preferring --parallel over the config default
+func parallelism(flag int, cfg config.Config) int {
+	if flag > 0 {
+		return flag
+	}
+	return cfg.Parallel
+}
+
+// daemon
+// ----------------------------------------------------------------------------
+type DaemonCmd struct{}
+
+func (cmd DaemonCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	return commands.Daemon(ctx, executor, cfg)
+}
+
+// systemd
+// ----------------------------------------------------------------------------
+type SystemdGenerateCmd struct {
+	Target            BorgTarget `arg:"positional"`
+	User              bool       `arg:"--user"`
+	Install           bool       `arg:"--install"`
+	Enable            bool       `arg:"--enable"`
+	Nice              int        `arg:"--nice" default:"10"`
+	IOSchedulingClass string     `arg:"--io-scheduling-class" default:"idle"`
+	RunAs             string     `arg:"--run-as"`
+}
+
+func (cmd SystemdGenerateCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	configFile, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return err
+	}
+	opts := commands.SystemdOptions{
+		User:              cmd.User,
+		Executable:        executable,
+		ConfigFile:        configFile,
+		Nice:              cmd.Nice,
+		IOSchedulingClass: cmd.IOSchedulingClass,
+	}
+	if !cmd.User {
+		if opts.RunAs, opts.ConfigHome, err = commands.SystemdServiceUser(cmd.RunAs); err != nil {
+			return err
+		}
+	}
+	return commands.SystemdGenerate(ctx, targets, opts, cmd.Install || cmd.Enable, cmd.Enable)
+}
+
+type SystemdCmd struct {
+	Generate *SystemdGenerateCmd `arg:"subcommand:generate"`
+}
+
+func (cmd SystemdCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	return cmd.Generate.Run(ctx, executor, cfg)
+}
+
+// status
+// ----------------------------------------------------------------------------
+type StatusCmd struct {
+	Target BorgTarget `arg:"positional"`
+}
+
+func (cmd StatusCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.Status(targets)
+}
+
+// config
+// ----------------------------------------------------------------------------
+type ConfigCheckCmd struct{}
+
+func (cmd ConfigCheckCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	return commands.ConfigCheck(cfg.Path)
+}
+
+type ConfigSchemaCmd struct{}
+
+func (cmd ConfigSchemaCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	return commands.ConfigSchema()
+}
+
+type ConfigCmd struct {
+	Check  *ConfigCheckCmd  `arg:"subcommand:check"`
+	Schema *ConfigSchemaCmd `arg:"subcommand:schema"`
+}
+
+func (cmd ConfigCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	if cmd.Schema != nil {
+		return cmd.Schema.Run(ctx, executor, cfg)
+	}
+	return cmd.Check.Run(ctx, executor, cfg)
+}
+
+// prune
+// ----------------------------------------------------------------------------
+type PruneCmd struct {
+	Target BorgTarget `arg:"required,positional"`
+	DryRun bool       `arg:"--dry-run"`
+	List   bool       `arg:"--list"`
+}
+
+func (cmd PruneCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.Prune(ctx, executor, targets, cmd.DryRun, cmd.List)
+}
+
+// compact
+// ----------------------------------------------------------------------------
+type CompactCmd struct {
 	Target BorgTarget `arg:"required,positional"`
 }
 
-func (cmd CreateCmd) Run(cfg config.Config) int {
+func (cmd CompactCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
-	commands.Create(targets)
-	return 0
+	return commands.Compact(ctx, executor, targets)
+}
+
+// upload
+// ----------------------------------------------------------------------------
+type UploadCmd struct {
+	Target BorgTarget `arg:"required,positional"`
+}
+
+func (cmd UploadCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.Upload(ctx, targets)
+}
+
+// extract
+// ----------------------------------------------------------------------------
+type ExtractCmd struct {
+	Target          SingleBorgTarget `arg:"required,positional"`
+	Paths           []string         `arg:"positional"`
+	Archive         string           `arg:"--archive"`
+	Latest          bool             `arg:"--latest"`
+	Dest            string           `arg:"required,--dest"`
+	DryRun          bool             `arg:"--dry-run"`
+	StripComponents int              `arg:"--strip-components"`
+}
+
+func (cmd ExtractCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	target := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)[0]
+	return commands.Extract(ctx, executor, target, cmd.Archive, cmd.Latest, cmd.Paths, cmd.Dest, cmd.DryRun, cmd.StripComponents)
+}
+
+// mount
+// ----------------------------------------------------------------------------
+type MountCmd struct {
+	Target     SingleBorgTarget `arg:"required,positional"`
+	Mountpoint string           `arg:"required,positional"`
+	Archive    string           `arg:"--archive"`
+}
+
+func (cmd MountCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	target := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)[0]
+	return commands.Mount(ctx, executor, target, cfg.GetTargets("", ""), cmd.Mountpoint, cmd.Archive)
+}
+
+// umount
+// ----------------------------------------------------------------------------
+type UmountCmd struct {
+	Mountpoint string `arg:"positional"`
+	All        bool   `arg:"--all"`
+}
+
+func (cmd UmountCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets("", "")
+	return commands.Umount(ctx, executor, targets, cmd.Mountpoint, cmd.All)
 }
 
 // export-key
@@ -126,65 +319,144 @@ type ExportKeyCmd struct {
 	Target BorgTarget `arg:"required,positional"`
 }
 
-func (cmd ExportKeyCmd) Run(cfg config.Config) int {
+func (cmd ExportKeyCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
-	commands.ExportKey(targets)
-	return 0
+	return commands.ExportKey(ctx, executor, targets)
 }
 
 // import-key
 // ----------------------------------------------------------------------------
 type ImportKeyCmd struct {
 	Target       SingleBorgTarget `arg:"required,positional"`
-	Keyfile      string           `arg:"required"`
+	Keyfile      string           `arg:"--keyfile"`
 	PasswordFile string           `arg:"--password-file"`
+	Paper        bool             `arg:"--paper"`
 }
 
-func (cmd ImportKeyCmd) Run(cfg config.Config) int {
+func (cmd ImportKeyCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	target := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)[0]
-	commands.ImportKey(target, cmd.Keyfile, cmd.PasswordFile)
-	return 0
+	return commands.ImportKey(ctx, executor, target, cmd.Keyfile, cmd.PasswordFile, cmd.Paper)
+}
+
+// rotate-passphrase
+// ----------------------------------------------------------------------------
+type RotatePassphraseCmd struct {
+	Target BorgTarget `arg:"required,positional"`
+}
+
+func (cmd RotatePassphraseCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	targets := cfg.GetTargets(cmd.Target.Archive, cmd.Target.Store)
+	return commands.RotatePassphrase(ctx, executor, targets)
 }
 
 // clean
 // ----------------------------------------------------------------------------
 type CleanCmd struct{}
 
-func (cmd CleanCmd) Run(cfg config.Config) int {
+func (cmd CleanCmd) Run(ctx context.Context, executor borg.Executor, cfg config.Config) error {
 	targets := cfg.GetTargets("", "")
-	commands.Clean(targets)
-	return 0
+	return commands.Clean(targets)
 }
 
 // Arguments struct defines the CLI Interface
 type Arguments struct {
-	ListTargets *ListTargetsCmd `arg:"subcommand:list-targets"`
-	Initialise  *InitialiseCmd  `arg:"subcommand:init"`
-	Info        *InfoCmd        `arg:"subcommand:info"`
-	List        *ListCmd        `arg:"subcommand:list"`
-	Create      *CreateCmd      `arg:"subcommand:create"`
-	ExportKey   *ExportKeyCmd   `arg:"subcommand:export-key"`
-	ImportKey   *ImportKeyCmd   `arg:"subcommand:import-key"`
-	Clean       *CleanCmd       `arg:"subcommand:clean"`
+	ListTargets      *ListTargetsCmd      `arg:"subcommand:list-targets"`
+	Initialise       *InitialiseCmd       `arg:"subcommand:init"`
+	Info             *InfoCmd             `arg:"subcommand:info"`
+	List             *ListCmd             `arg:"subcommand:list"`
+	Create           *CreateCmd           `arg:"subcommand:create"`
+	Run              *RunCmd              `arg:"subcommand:run"`
+	Daemon           *DaemonCmd           `arg:"subcommand:daemon"`
+	Systemd          *SystemdCmd          `arg:"subcommand:systemd"`
+	Status           *StatusCmd           `arg:"subcommand:status"`
+	Config           *ConfigCmd           `arg:"subcommand:config"`
+	Prune            *PruneCmd            `arg:"subcommand:prune"`
+	Compact          *CompactCmd          `arg:"subcommand:compact"`
+	Upload           *UploadCmd           `arg:"subcommand:upload"`
+	Extract          *ExtractCmd          `arg:"subcommand:extract"`
+	Mount            *MountCmd            `arg:"subcommand:mount"`
+	Umount           *UmountCmd           `arg:"subcommand:umount"`
+	ExportKey        *ExportKeyCmd        `arg:"subcommand:export-key"`
+	ImportKey        *ImportKeyCmd        `arg:"subcommand:import-key"`
+	RotatePassphrase *RotatePassphraseCmd `arg:"subcommand:rotate-passphrase"`
+	Clean            *CleanCmd            `arg:"subcommand:clean"`
+
+	ConfigFile string        `arg:"-c,--config-file"`
+	Wait       time.Duration `arg:"--wait"`
+}
+
+// ReadsConfigItself returns true for subcommands which read the configuration file themselves,
+// and so must run even if it is invalid
+func (args *Arguments) ReadsConfigItself() bool {
+	return args.Config != nil
+}
 
-	ConfigFile string `arg:"-c,--config-file"`
+// RecordsRuns returns true for subcommands which record runs in the status of their targets,
+// after which the metrics file is rewritten. The daemon writes it after each of its own runs
+func (args *Arguments) RecordsRuns() bool {
+	return args.Initialise != nil || args.Create != nil || args.Run != nil || args.Prune != nil ||
+		args.Compact != nil || args.Upload != nil || args.ImportKey != nil || args.RotatePassphrase != nil
+}
+
+// stderr receives the errors returned by subcommands. They are kept off stdout, which may hold a json or yaml document
+var stderr io.Writer = os.Stderr
+
+// exitCode writes the error returned by a subcommand to stderr and converts it to the process exit code.
+// If borg failed, its own exit code is used. Any other error exits with 1
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	code := 1
+	for _, e := range errs {
+		fmt.Fprintln(stderr, e)
+		var exitErr *borg.ExitError
+		if errors.As(e, &exitErr) && exitErr.Code > code {
+			code = exitErr.Code
+		}
+	}
+	return code
 }
 
 // RunSubCommand method finds the CLI subcommand specified and calls it's Run() method
-func (args *Arguments) RunSubcommand(cfg config.Config) int {
+// The returned value is the process exit code
+func (args *Arguments) RunSubcommand(ctx context.Context, executor borg.Executor, cfg config.Config) int {
 	subCommands := []RunnableCommand{
 		args.ListTargets,
 		args.Initialise,
 		args.Info,
 		args.List,
 		args.Create,
+		args.Run,
+		args.Daemon,
+		args.Systemd,
+		args.Status,
+		args.Config,
+		args.Prune,
+		args.Compact,
+		args.Upload,
+		args.Extract,
+		args.Mount,
+		args.Umount,
 		args.ExportKey,
 		args.ImportKey,
+		args.RotatePassphrase,
 		args.Clean,
 	}
 	for _, cmd := range subCommands {
 		if !reflect.ValueOf(cmd).IsNil() {
-			return cmd.Run(cfg)
+			ctx = commands.WithLockWait(ctx, args.Wait)
+			err := cmd.Run(ctx, executor, cfg)
+			if cfg.MetricsFile != "" && args.RecordsRuns() {
+				if err := commands.WriteMetrics(cfg.MetricsFile, commands.AllTargets(cfg)); err != nil {
+					logger.Warn("Unable to write metrics: %s", err)
+				}
+			}
+			return exitCode(err)
 		}
 	}
 
@@ -211,6 +483,50 @@ func ParseArgs() *Arguments {
 
 	}
 
+	if args.ImportKey != nil && args.ImportKey.Keyfile == "" && !args.ImportKey.Paper {
+		p.Fail("import-key requires --keyfile unless --paper is set")
+	}
+
+	if args.Extract != nil {
+		if (args.Extract.Archive == "") == !args.Extract.Latest {
+			p.Fail("extract requires exactly one of --archive or --latest")
+		}
+		if args.Extract.StripComponents < 0 {
+			p.Fail("--strip-components must not be negative")
+		}
+	}
+
+	if args.Wait < 0 {
+		p.Fail("--wait must not be negative")
+	}
+
+	if (args.Create != nil && args.Create.Parallel < 0) || (args.Run != nil && args.Run.Parallel < 0) {
+		p.Fail("--parallel must not be negative")
+	}
+
+	if args.Systemd != nil {
+		if args.Systemd.Generate == nil {
+			p.Fail("systemd requires a subcommand: generate")
+		}
+		if args.Systemd.Generate.User && args.Systemd.Generate.RunAs != "" {
+			p.Fail("--run-as cannot be used with --user")
+		}
+		if nice := args.Systemd.Generate.Nice; nice < -20 || nice > 19 {
+			p.Fail("--nice must be between -20 and 19")
+		}
+		if !slices.Contains([]string{"realtime", "best-effort", "idle"}, args.Systemd.Generate.IOSchedulingClass) {
+			p.Fail("--io-scheduling-class must be one of realtime, best-effort or idle")
+		}
+	}
+
+	if args.Config != nil && args.Config.Check == nil && args.Config.Schema == nil {
+		p.Fail("config requires a subcommand: check or schema")
+	}
+
+	if args.Umount != nil && (args.Umount.Mountpoint == "") == !args.Umount.All {
+		p.Fail("umount requires either MOUNTPOINT or --all")
+	}
+
 	if p.Subcommand() == nil {
 		p.WriteHelp(os.Stderr)
 		os.Exit(1)
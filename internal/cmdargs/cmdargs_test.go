@@ -0,0 +1,74 @@
+package cmdargs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"codeberg.org/jstover/borgdrone/internal/borg/borgtest"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func newTarget(t *testing.T, archive string) config.Target {
+	t.Helper()
+	target := config.Target{ArchiveName: archive, StoreName: "usb", StoreType: config.LocalStore}
+	target.Store.Local = "/backup"
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target.GetPasswordFile(), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.MarkInitialised(); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func TestRunSubcommandStructuredOutputWithFailure(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	cfg := config.Config{TargetMap: map[string]config.Target{
+		"desktop:usb": newTarget(t, "desktop"),
+		"laptop:usb":  newTarget(t, "laptop"),
+	}}
+	// Targets run in name order, so desktop fails and laptop succeeds
+	executor := borgtest.New().
+		Respond("info", borgtest.Response{ExitCode: 2, Stderr: []string{"Repository does not exist"}}).
+		Respond("info", borgtest.Response{Stdout: []string{`{"repository": {"id": "abc", "location": "/backup/laptop"}}`}})
+	args := Arguments{Info: &InfoCmd{Format: "json"}}
+
+	var errOut bytes.Buffer
+	stderr = &errOut
+	t.Cleanup(func() { stderr = os.Stderr })
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	code := args.RunSubcommand(context.Background(), executor, cfg)
+	os.Stdout = stdout
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("stdout is not valid json: %v\n%s", err, out)
+	}
+	if _, ok := doc["laptop:usb"]; !ok || len(doc) != 1 {
+		t.Errorf("document = %v, want only laptop:usb", doc)
+	}
+	if !strings.Contains(errOut.String(), "desktop:usb: borg info failed (exit code 2)") {
+		t.Errorf("stderr = %q, want the desktop:usb error", errOut.String())
+	}
+}
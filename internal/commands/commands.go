@@ -1,41 +1,70 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"codeberg.org/jstover/borgdrone/internal/borg"
 	"codeberg.org/jstover/borgdrone/internal/config"
 	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/rclone"
 	"gopkg.in/yaml.v3"
 )
 
-func ListTargets(cfg config.Config, format string) {
+// warn logs a warning, or writes it to stderr if quiet is set because stdout is reserved for structured output
+func warn(quiet bool, msg string, a ...any) {
+	if quiet {
+		fmt.Fprintf(os.Stderr, msg+"\n", a...)
+		return
+	}
+	logger.Warn(msg, a...)
+}
+
+// runBorg runs a borg command, logging and ignoring warnings so that only failures are returned
+func runBorg(ctx context.Context, executor borg.Executor, opts borg.Options, args ...string) (*borg.Result, error) {
+	result, err := executor.Run(ctx, opts, args...)
+	if borg.IsWarning(err) {
+		warn(opts.Quiet, "%s", err)
+		return result, nil
+	}
+	return result, err
+}
+
+// targetError prefixes an error with the name of the target it occurred for
+func targetError(target config.Target, err error) error {
+	return fmt.Errorf("%s: %w", target.GetName(), err)
+}
+
+func ListTargets(cfg config.Config, format string) error {
 	switch format {
 	case "json":
 		data, err := json.MarshalIndent(cfg.TargetMap, "", "  ")
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		logger.Info(string(data))
+		logger.Info("%s", data)
 
 	case "yaml":
 		data, err := yaml.Marshal(cfg.TargetMap)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		logger.Info(string(data))
+		logger.Info("%s", data)
 
 	case "text":
 		for name, target := range cfg.TargetMap {
-			logger.Info(name)
+			logger.Info("%s", name)
 			logger.Info("Include     | %s", strings.Join(target.Archive.Include, ", "))
 			if len(target.Archive.Exclude) > 0 {
 				logger.Info("Exclude     | %s", strings.Join(target.Archive.Exclude, ", "))
@@ -43,51 +72,175 @@ func ListTargets(cfg config.Config, format string) {
 			logger.Info("Repository  | %s [%s]", target.StoreName, target.GetBorgRepositoryPath())
 			logger.Info("")
 		}
+
+	default:
+		return fmt.Errorf("unknown format '%s'", format)
 	}
+	return nil
 }
 
-func Initialise(targets []config.Target) {
+func Initialise(ctx context.Context, executor borg.Executor, targets []config.Target) error {
 	logger.Info("Runnning Initialise")
+	errs := []error{}
 	for _, target := range targets {
 		if target.IsInitialised() {
 			logger.Warn("%s already initialised", target.GetName())
 			continue
 		}
 		logger.Info("Initialising " + target.GetName())
-		target.CreatePasswordFile()
-		runner := borg.Runner{Env: target.GetEnvironment()}
-		if runner.Run("init", "--encryption", target.Encryption) {
-			target.MarkInitialised()
+		err := withTargetLock(ctx, target, "init", func() error {
+			// Another process may have initialised the target while this one waited for the lock
+			if target.IsInitialised() {
+				logger.Warn("%s already initialised", target.GetName())
+				return nil
+			}
+			if err := createPassphrase(target); err != nil {
+				return err
+			}
+			opts := borg.Options{Env: target.GetEnvironment()}
+			if _, err := runBorg(ctx, executor, opts, "init", "--encryption", target.Encryption); err != nil {
+				return err
+			}
+			return target.MarkInitialised()
+		})
+		if err != nil {
+			errs = append(errs, targetError(target, err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
-func Info(targets []config.Target) {
+// createPassphrase generates and stores a passphrase for a new repository.
+// Backends which borgdrone cannot write to must already provide a passphrase
+func createPassphrase(target config.Target) error {
+	provider, err := target.GetSecretProvider()
+	if err != nil {
+		return err
+	}
+	store, ok := provider.(config.SecretStore)
+	if !ok {
+		if _, err := provider.Passphrase(); err != nil {
+			return fmt.Errorf("unable to read passphrase: %w", err)
+		}
+		return nil
+	}
+	passphrase, err := config.GeneratePassphrase(target.Passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+	if err := store.Create(passphrase); err != nil {
+		return fmt.Errorf("failed to store passphrase: %w", err)
+	}
+	return nil
+}
+
+func Info(ctx context.Context, executor borg.Executor, targets []config.Target, format string) error {
+	if format != "text" {
+		argv := func(config.Target) []string { return []string{"info", "--json"} }
+		return printStructured(ctx, executor, targets, format, argv, borg.ParseInfoOutput)
+	}
+	errs := []error{}
 	for _, target := range targets {
 		if !target.IsInitialised() {
 			logger.Warn("target '%s' has not been initialised", target.GetName())
 			continue
 		}
 		logger.Info("----- %s -----\n", target.GetName())
-		runner := borg.Runner{Env: target.GetEnvironment()}
-		runner.Run("info")
+		opts := borg.Options{Env: target.GetEnvironment()}
+		if _, err := runBorg(ctx, executor, opts, "info"); err != nil {
+			errs = append(errs, targetError(target, err))
+		}
 	}
+	return errors.Join(errs...)
 }
 
-func List(targets []config.Target) {
+// globArchives returns the --glob-archives arguments which restrict a borg command to the archives created by a target
+func globArchives(target config.Target) []string {
+	if glob := target.GetArchiveGlob(); glob != "" {
+		return []string{"--glob-archives", glob}
+	}
+	return nil
+}
+
+// List prints the archives created by each target, or every archive in their repositories if all is set
+func List(ctx context.Context, executor borg.Executor, targets []config.Target, format string, all bool) error {
+	scope := globArchives
+	if all {
+		scope = func(config.Target) []string { return nil }
+	}
+	if format != "text" {
+		argv := func(target config.Target) []string {
+			return append([]string{"list", "--json"}, scope(target)...)
+		}
+		return printStructured(ctx, executor, targets, format, argv, borg.ParseListOutput)
+	}
+	errs := []error{}
 	for _, target := range targets {
 		if !target.IsInitialised() {
 			logger.Warn("target '%s' has not been initialised", target.GetName())
 			continue
 		}
 		logger.Info("----- %s -----", target.GetName())
-		runner := borg.Runner{Env: target.GetEnvironment()}
-		runner.Run("list")
+		opts := borg.Options{Env: target.GetEnvironment()}
+		if _, err := runBorg(ctx, executor, opts, append([]string{"list"}, scope(target)...)...); err != nil {
+			errs = append(errs, targetError(target, err))
+		}
 	}
+	return errors.Join(errs...)
 }
 
-func Create(targets []config.Target) {
+// printStructured runs the borg --json command returned by argv for every target, and prints the decoded results
+// as a single json or yaml document keyed by target name
+func printStructured[T any](ctx context.Context, executor borg.Executor, targets []config.Target, format string, argv func(config.Target) []string, parse func([]string) (T, error)) error {
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("unknown format '%s'", format)
+	}
+	errs := []error{}
+	docs := make(map[string]T)
+	for _, target := range targets {
+		if !target.IsInitialised() {
+			warn(true, "target '%s' has not been initialised", target.GetName())
+			continue
+		}
+		opts := borg.Options{Env: target.GetEnvironment(), Quiet: true}
+		result, err := runBorg(ctx, executor, opts, argv(target)...)
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+			continue
+		}
+		doc, err := parse(result.Stdout)
+		if err != nil {
+			errs = append(errs, targetError(target, fmt.Errorf("unable to parse borg output: %w", err)))
+			continue
+		}
+		docs[target.GetName()] = doc
+	}
 
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(docs, "", "  ")
+	case "yaml":
+		data, err = yaml.Marshal(docs)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return errors.Join(errs...)
+}
+
+func Create(ctx context.Context, executor borg.Executor, targets []config.Target, parallel int) error {
+	logger.Info("Running Create")
+	errs := []error{}
+	for _, result := range runPipelines(ctx, executor, targets, parallel) {
+		errs = append(errs, result.Err)
+	}
+	return errors.Join(errs...)
+}
+
+func create(ctx context.Context, executor borg.Executor, target config.Target, record *RunRecord) error {
 	expand := func(path string) string {
 		if !strings.HasPrefix(path, "~/") {
 			return path
@@ -96,30 +249,289 @@ func Create(targets []config.Target) {
 		return filepath.Join(dirname, path[2:])
 	}
 
-	logger.Info("Running Create")
+	argv := []string{"create", "--stats", "--json", "--compression", target.Compression}
+	if target.OneFileSystem {
+		argv = append(argv, "--one-file-system")
+	}
+	for _, p := range target.Archive.Exclude {
+		argv = append(argv, "--exclude")
+		argv = append(argv, expand(p))
+	}
+	argv = append(argv, "::"+target.GetArchiveName())
+	for _, p := range target.Archive.Include {
+		argv = append(argv, expand(p))
+	}
+	logger.Info("%+v", argv)
+	opts := borg.Options{Env: target.GetEnvironment(), LogPrefix: target.GetName(), Quiet: true}
+	result, err := runBorg(ctx, executor, opts, argv...)
+	if err != nil {
+		return err
+	}
+	output, err := borg.ParseCreateOutput(result.Stdout)
+	if err != nil {
+		// The archive was created, so missing statistics are not a failure
+		logger.Warn("%s: unable to parse borg create output: %s", target.GetName(), err)
+		return nil
+	}
+	stats := output.Archive.Stats
+	logger.Info("%s: created %s: %d files, %s original, %s compressed, %s deduplicated", target.GetName(), output.Archive.Name,
+		stats.NFiles, formatBytes(stats.OriginalSize), formatBytes(stats.CompressedSize), formatBytes(stats.DeduplicatedSize))
+	record.Archive = &output.Archive
+	record.Cache = &output.Cache.Stats
+	return nil
+}
+
+func Prune(ctx context.Context, executor borg.Executor, targets []config.Target, dryRun bool, list bool) error {
+	logger.Info("Running Prune")
+	errs := []error{}
 	for _, target := range targets {
+		if !target.IsInitialised() {
+			logger.Warn("target '%s' has not been initialised", target.GetName())
+			continue
+		}
 		logger.Info("----- %s -----", target.GetName())
-		argv := []string{"create", "--stats", "--compression", target.Compression}
-		if target.OneFileSystem {
-			argv = append(argv, "--one-file-system")
+		err := withTargetLock(ctx, target, "prune", func() error {
+			return prune(ctx, executor, target, dryRun, list)
+		})
+		if err != nil {
+			errs = append(errs, targetError(target, err))
 		}
-		for _, p := range target.Archive.Exclude {
-			argv = append(argv, "--exclude")
-			argv = append(argv, expand(p))
+	}
+	return errors.Join(errs...)
+}
+
+func prune(ctx context.Context, executor borg.Executor, target config.Target, dryRun bool, list bool) error {
+	if target.Prune.IsEmpty() {
+		return errors.New("no prune options configured. Refusing to prune")
+	}
+	argv := []string{"prune"}
+	if target.Prune.KeepDaily > 0 {
+		argv = append(argv, "--keep-daily", strconv.Itoa(target.Prune.KeepDaily))
+	}
+	if target.Prune.KeepWeekly > 0 {
+		argv = append(argv, "--keep-weekly", strconv.Itoa(target.Prune.KeepWeekly))
+	}
+	if target.Prune.KeepMonthly > 0 {
+		argv = append(argv, "--keep-monthly", strconv.Itoa(target.Prune.KeepMonthly))
+	}
+	if target.Prune.KeepYearly > 0 {
+		argv = append(argv, "--keep-yearly", strconv.Itoa(target.Prune.KeepYearly))
+	}
+	// Archives which do not match the archive name of the target, such as those created before archive_name
+	// was set, are never pruned
+	if glob := target.GetArchiveGlob(); glob != "" {
+		logger.Info("Pruning archives matching %s", glob)
+	}
+	argv = append(argv, globArchives(target)...)
+	if dryRun {
+		argv = append(argv, "--dry-run")
+	}
+	if list {
+		argv = append(argv, "--list")
+	}
+	logger.Info("%+v", argv)
+	opts := borg.Options{Env: target.GetEnvironment(), LogPrefix: target.GetName()}
+	_, err := runBorg(ctx, executor, opts, argv...)
+	return err
+}
+
+func Compact(ctx context.Context, executor borg.Executor, targets []config.Target) error {
+	logger.Info("Running Compact")
+	errs := []error{}
+	for _, target := range targets {
+		if !target.IsInitialised() {
+			logger.Warn("target '%s' has not been initialised", target.GetName())
+			continue
 		}
-		argv = append(argv, "::{now}")
-		for _, p := range target.Archive.Include {
-			argv = append(argv, expand(p))
+		logger.Info("----- %s -----", target.GetName())
+		err := withTargetLock(ctx, target, "compact", func() error {
+			return compact(ctx, executor, target)
+		})
+		if err != nil {
+			errs = append(errs, targetError(target, err))
 		}
-		logger.Info("%+v", argv)
-		runner := borg.Runner{Env: target.GetEnvironment()}
-		runner.Run(argv...)
 	}
+	return errors.Join(errs...)
 }
 
-func ExportKey(targets []config.Target) {
+// compactFreedRegex matches the summary line which borg compact logs at the end of a --verbose run
+var compactFreedRegex = regexp.MustCompile(`(?i)compaction freed about (.+) repository space`)
+
+func compact(ctx context.Context, executor borg.Executor, target config.Target) error {
+	opts := borg.Options{Env: target.GetEnvironment(), LogPrefix: target.GetName()}
+	result, err := runBorg(ctx, executor, opts, "compact", "--verbose")
+	if err != nil {
+		return err
+	}
+	for _, line := range result.Stderr {
+		if m := compactFreedRegex.FindStringSubmatch(line); m != nil {
+			logger.Info("%s: compaction freed %s", target.GetName(), m[1])
+		}
+	}
+	return nil
+}
+
+func Upload(ctx context.Context, targets []config.Target) error {
+	logger.Info("Running Upload")
+	errs := []error{}
+	for _, target := range targets {
+		if target.RcloneUploadPath == "" {
+			logger.Warn("target '%s' has no rclone_upload_path configured", target.GetName())
+			continue
+		}
+		logger.Info("----- %s -----", target.GetName())
+		err := withTargetLock(ctx, target, "upload", func() error {
+			return upload(ctx, target)
+		})
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func upload(ctx context.Context, target config.Target) error {
+	if target.StoreType != config.LocalStore {
+		return errors.New("not a local store and cannot be uploaded")
+	}
+	argv := []string{"sync", target.GetBorgRepositoryPath(), target.GetRcloneUploadPath()}
+	logger.Info("%+v", argv)
+	runner := rclone.Runner{Env: os.Environ(), LogPrefix: target.GetName()}
+	return runner.Run(ctx, argv...)
+}
+
+func RotatePassphrase(ctx context.Context, executor borg.Executor, targets []config.Target) error {
+	logger.Info("Running RotatePassphrase")
+	errs := []error{}
+	for _, target := range targets {
+		if !target.IsInitialised() {
+			logger.Warn("target '%s' has not been initialised", target.GetName())
+			continue
+		}
+		logger.Info("----- %s -----", target.GetName())
+		err := withTargetLock(ctx, target, "rotate-passphrase", func() error {
+			return rotatePassphrase(ctx, executor, target)
+		})
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func rotatePassphrase(ctx context.Context, executor borg.Executor, target config.Target) error {
+	provider, err := target.GetSecretProvider()
+	if err != nil {
+		return err
+	}
+	store, ok := provider.(config.SecretStore)
+	if !ok {
+		return fmt.Errorf("passphrase backend '%s' is read-only and cannot be rotated by borgdrone", target.Passphrase.Backend)
+	}
+
+	passphrase, err := config.GeneratePassphrase(target.Passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to generate passphrase: %w", err)
+	}
+
+	// Stage the new passphrase before changing the key, so it is never lost if borg succeeds but we do not
+	staged, err := store.Stage(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to stage new passphrase: %w", err)
+	}
+
+	env := append(target.GetEnvironment(), "BORG_NEW_PASSPHRASE="+passphrase)
+	opts := borg.Options{Env: env}
+	if _, err := runBorg(ctx, executor, opts, "key", "change-passphrase"); err != nil {
+		if discardErr := store.Discard(); discardErr != nil {
+			logger.Warn("failed to remove staged passphrase %s: %s", staged, discardErr)
+		}
+		return err
+	}
+
+	if err := store.Commit(); err != nil {
+		return fmt.Errorf("passphrase was changed but could not be stored. The new passphrase is in %s: %w", staged, err)
+	}
+	logger.Info("Rotated passphrase for %s", target.GetName())
+	return nil
+}
+
+// resolveArchive finds the name of an archive in the target's repository.
+// If latest is true the most recent archive created by the target is returned. Otherwise name must exist in the
+// repository, which also finds archives named before archive_name was set or matching another target's template
+func resolveArchive(ctx context.Context, executor borg.Executor, target config.Target, name string, latest bool) (string, error) {
+	opts := borg.Options{Env: target.GetEnvironment(), Quiet: true}
+	argv := []string{"list", "--json"}
+	if latest {
+		argv = append(argv, globArchives(target)...)
+	}
+	result, err := runBorg(ctx, executor, opts, argv...)
+	if err != nil {
+		return "", err
+	}
+	list, err := borg.ParseListOutput(result.Stdout)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse archive list: %w", err)
+	}
+	if len(list.Archives) == 0 {
+		return "", errors.New("repository contains no archives")
+	}
+	if latest {
+		archives := slices.SortedFunc(slices.Values(list.Archives), func(a, b borg.ArchiveSummary) int {
+			return strings.Compare(a.Time, b.Time)
+		})
+		return archives[len(archives)-1].Name, nil
+	}
+	for _, a := range list.Archives {
+		if a.Name == name {
+			return a.Name, nil
+		}
+	}
+	return "", fmt.Errorf("archive '%s' does not exist", name)
+}
+
+func Extract(ctx context.Context, executor borg.Executor, target config.Target, archive string, latest bool, paths []string, dest string, dryRun bool, stripComponents int) error {
+	logger.Info("Running Extract")
+	if !target.IsInitialised() {
+		return fmt.Errorf("target '%s' has not been initialised", target.GetName())
+	}
+
+	name, err := resolveArchive(ctx, executor, target, archive, latest)
+	if err != nil {
+		return targetError(target, err)
+	}
+	logger.Info("Extracting %s from %s into %s", name, target.GetName(), dest)
+
+	argv := []string{"extract"}
+	opts := borg.Options{Env: target.GetEnvironment()}
+	if dryRun {
+		// A dry run only lists what would be extracted, so the destination is not created
+		argv = append(argv, "--dry-run", "--list")
+	} else {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("unable to create destination: %w", err)
+		}
+		opts.Dir = dest
+	}
+	if stripComponents > 0 {
+		argv = append(argv, "--strip-components", strconv.Itoa(stripComponents))
+	}
+	argv = append(argv, "::"+name)
+	for _, p := range paths {
+		// borg stores paths without the leading slash
+		argv = append(argv, strings.TrimLeft(p, "/"))
+	}
+	logger.Info("%+v", argv)
+	if _, err := runBorg(ctx, executor, opts, argv...); err != nil {
+		return targetError(target, err)
+	}
+	return nil
+}
+
+func ExportKey(ctx context.Context, executor borg.Executor, targets []config.Target) error {
 	passwords := make(map[string]string)
 	exported := []string{}
+	errs := []error{}
 
 	for _, target := range targets {
 		if !target.IsInitialised() {
@@ -130,16 +542,28 @@ func ExportKey(targets []config.Target) {
 		key := target.GetKeyfile()
 		pkey := target.GetPaperKeyfile()
 
-		runner := borg.Runner{Env: target.GetEnvironment()}
-		runner.Run("key", "export", "--paper", "::", pkey)
+		opts := borg.Options{Env: target.GetEnvironment()}
+		if _, err := runBorg(ctx, executor, opts, "key", "export", "--paper", "::", pkey); err != nil {
+			errs = append(errs, targetError(target, err))
+			continue
+		}
 		logger.Debug("Exported %s", pkey)
 
-		runner.Run("key", "export", "::", pkey)
-		logger.Debug("Exported %s", pkey)
+		if _, err := runBorg(ctx, executor, opts, "key", "export", "::", key); err != nil {
+			errs = append(errs, targetError(target, err))
+			continue
+		}
+		logger.Debug("Exported %s", key)
 
-		pw, err := os.ReadFile(target.GetPasswordFile())
+		provider, err := target.GetSecretProvider()
 		if err != nil {
-			logger.Fatal(err.Error(), 3)
+			errs = append(errs, targetError(target, err))
+			continue
+		}
+		pw, err := provider.Passphrase()
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+			continue
 		}
 
 		exported = append(exported, key)
@@ -165,16 +589,80 @@ func ExportKey(targets []config.Target) {
 		}
 	}
 	logger.Info("")
+	return errors.Join(errs...)
 }
 
-func ImportKey(target config.Target, keyFile string, passwordFile string) {
+func ImportKey(ctx context.Context, executor borg.Executor, target config.Target, keyFile string, passwordFile string, paper bool) (err error) {
 	logger.Info("Running ImportKey")
-	logger.Info("Target = %+v", target)
-	logger.Info("Key File = %s", keyFile)
-	logger.Info("Password File = %s", passwordFile)
+	if target.IsInitialised() {
+		return fmt.Errorf("%s already initialised", target.GetName())
+	}
+	lock, err := lockTarget(ctx, target)
+	if err != nil {
+		return targetError(target, err)
+	}
+	defer lock.Unlock()
+	// Another process may have initialised the target while this one waited for the lock
+	if target.IsInitialised() {
+		return fmt.Errorf("%s already initialised", target.GetName())
+	}
+	record := RunRecord{Command: "import-key", Start: time.Now()}
+	defer func() { recordRun(target, record, err) }()
+
+	provider, err := target.GetSecretProvider()
+	if err != nil {
+		return targetError(target, err)
+	}
+	if passwordFile != "" {
+		store, ok := provider.(config.SecretStore)
+		if !ok {
+			return fmt.Errorf("passphrase backend '%s' is read-only. --password-file cannot be used", target.Passphrase.Backend)
+		}
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read password file: %w", err)
+		}
+		if _, err := store.Stage(string(data)); err != nil {
+			return fmt.Errorf("failed to import password file: %w", err)
+		}
+		if err := store.Commit(); err != nil {
+			return fmt.Errorf("failed to import password file: %w", err)
+		}
+		logger.Info("Imported %s", passwordFile)
+	} else if _, err := provider.Passphrase(); err != nil {
+		return fmt.Errorf("unable to read passphrase: %w. Provide one with --password-file", err)
+	}
+
+	// borg reads a paper key line by line from stdin, and ignores the key file path
+	argv := []string{"key", "import"}
+	importOpts := borg.Options{Env: target.GetEnvironment()}
+	source := keyFile
+	if paper {
+		argv = append(argv, "--paper", "::")
+		importOpts.Stdin = os.Stdin
+		source = "paper key"
+	} else {
+		argv = append(argv, "::", keyFile)
+	}
+	if _, err := runBorg(ctx, executor, importOpts, argv...); err != nil {
+		return fmt.Errorf("failed to import %s: %w", source, err)
+	}
+	logger.Info("Imported %s", source)
+
+	// Make sure the imported key and password can actually open the repository
+	opts := borg.Options{Env: target.GetEnvironment()}
+	if _, err := runBorg(ctx, executor, opts, "info"); err != nil {
+		return fmt.Errorf("unable to access repository %s with the imported key and password: %w", target.GetBorgRepositoryPath(), err)
+	}
+
+	if err := target.MarkInitialised(); err != nil {
+		return targetError(target, err)
+	}
+	logger.Info("%s initialised", target.GetName())
+	return nil
 }
 
-func Clean(targets []config.Target) {
+func Clean(targets []config.Target) error {
 	keys := []string{}
 	for _, t := range targets {
 		keys = append(keys, t.GetKeyfile())
@@ -189,4 +677,5 @@ func Clean(targets []config.Target) {
 		}
 	}
 	logger.Info("%d files removed", n)
+	return nil
 }
@@ -0,0 +1,769 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/borg/borgtest"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/notify"
+)
+
+// setupEnv isolates the config directory and home directory of a test
+func setupEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", "/home/test")
+}
+
+// captureStdout returns what fn writes to stdout, where the logger writes
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func newTarget(archive string, store string) config.Target {
+	target := config.Target{
+		ArchiveName: archive,
+		StoreName:   store,
+		StoreType:   config.LocalStore,
+		Archive:     config.Archive{Include: []string{"/data"}, Exclude: []string{}},
+		Encryption:  "keyfile-blake2",
+		Compression: "lz4",
+		// Avoid depending on the hostname of the machine running the tests
+		ArchiveNameTemplate: "{archive}-{now}",
+	}
+	target.Store.Local = "/backup"
+	return target
+}
+
+func markInitialised(t *testing.T, target config.Target) {
+	t.Helper()
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target.GetPasswordFile(), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.MarkInitialised(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertArgs(t *testing.T, got [][]string, want [][]string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected borg calls\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*config.Target)
+		responses map[string]borgtest.Response
+		want      [][]string
+		wantErr   bool
+	}{
+		{
+			name: "defaults",
+			want: [][]string{
+				{"create", "--stats", "--json", "--compression", "lz4", "::laptop-{now}", "/data"},
+			},
+		},
+		{
+			name: "one file system with expanded excludes",
+			configure: func(target *config.Target) {
+				target.OneFileSystem = true
+				target.Compression = "zstd,3"
+				target.Archive.Include = []string{"~/Documents", "/etc"}
+				target.Archive.Exclude = []string{"~/Documents/tmp", "**/node_modules"}
+			},
+			want: [][]string{
+				{
+					"create", "--stats", "--json", "--compression", "zstd,3", "--one-file-system",
+					"--exclude", "/home/test/Documents/tmp", "--exclude", "**/node_modules",
+					"::laptop-{now}", "/home/test/Documents", "/etc",
+				},
+			},
+		},
+		{
+			name: "prune and compact after create",
+			configure: func(target *config.Target) {
+				target.Prune = config.PruneOptions{KeepDaily: 7, KeepMonthly: 6}
+				target.Compact = true
+			},
+			want: [][]string{
+				{"create", "--stats", "--json", "--compression", "lz4", "::laptop-{now}", "/data"},
+				{"prune", "--keep-daily", "7", "--keep-monthly", "6", "--glob-archives", "laptop-*"},
+				{"compact", "--verbose"},
+			},
+		},
+		{
+			name: "failed create stops pipeline",
+			configure: func(target *config.Target) {
+				target.Prune = config.PruneOptions{KeepDaily: 7}
+				target.Compact = true
+			},
+			responses: map[string]borgtest.Response{"create": {ExitCode: 2}},
+			want: [][]string{
+				{"create", "--stats", "--json", "--compression", "lz4", "::laptop-{now}", "/data"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "warnings do not stop pipeline",
+			configure: func(target *config.Target) {
+				target.Compact = true
+			},
+			responses: map[string]borgtest.Response{"create": {ExitCode: 1}},
+			want: [][]string{
+				{"create", "--stats", "--json", "--compression", "lz4", "::laptop-{now}", "/data"},
+				{"compact", "--verbose"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			if tt.configure != nil {
+				tt.configure(&target)
+			}
+			executor := borgtest.New()
+			for subcommand, r := range tt.responses {
+				executor.Respond(subcommand, r)
+			}
+
+			err := Create(context.Background(), executor, []config.Target{target}, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+
+			status, err := readStatus(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := status.LastPipeline; p == nil || (p.ExitCode != 0) != tt.wantErr {
+				t.Errorf("pipeline record = %+v, wantErr %v", p, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name    string
+		prune   config.PruneOptions
+		dryRun  bool
+		list    bool
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name:    "refuses without keep values",
+			want:    [][]string{},
+			wantErr: true,
+		},
+		{
+			name:  "every keep value",
+			prune: config.PruneOptions{KeepDaily: 7, KeepWeekly: 4, KeepMonthly: 6, KeepYearly: 1},
+			want: [][]string{
+				{
+					"prune", "--keep-daily", "7", "--keep-weekly", "4", "--keep-monthly", "6", "--keep-yearly", "1",
+					"--glob-archives", "laptop-*",
+				},
+			},
+		},
+		{
+			name:   "dry run",
+			prune:  config.PruneOptions{KeepDaily: 7},
+			dryRun: true,
+			want:   [][]string{{"prune", "--keep-daily", "7", "--glob-archives", "laptop-*", "--dry-run"}},
+		},
+		{
+			name:   "dry run with list",
+			prune:  config.PruneOptions{KeepWeekly: 4},
+			dryRun: true,
+			list:   true,
+			want:   [][]string{{"prune", "--keep-weekly", "4", "--glob-archives", "laptop-*", "--dry-run", "--list"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			target.Prune = tt.prune
+			markInitialised(t, target)
+			executor := borgtest.New()
+
+			err := Prune(context.Background(), executor, []config.Target{target}, tt.dryRun, tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Prune() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+		})
+	}
+}
+
+func TestCreateEnvironment(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	executor := borgtest.New()
+
+	if err := Create(context.Background(), executor, []config.Target{target}, 1); err != nil {
+		t.Fatal(err)
+	}
+	env := executor.Calls[0].Env
+	for _, want := range []string{"BORG_REPO=/backup/laptop", "BORG_PASSCOMMAND=cat '" + target.GetPasswordFile() + "'"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environment %q does not contain %q", env, want)
+		}
+	}
+}
+
+// trackingExecutor records the maximum number of concurrent borg invocations, overall and per store
+type trackingExecutor struct {
+	mu          sync.Mutex
+	active      map[string]int
+	total       int
+	maxTotal    int
+	maxPerStore int
+}
+
+func (e *trackingExecutor) Run(ctx context.Context, opts borg.Options, args ...string) (*borg.Result, error) {
+	store := ""
+	for _, v := range opts.Env {
+		if repo, ok := strings.CutPrefix(v, "BORG_REPO="); ok {
+			store = filepath.Dir(repo)
+		}
+	}
+	e.mu.Lock()
+	e.active[store]++
+	e.total++
+	e.maxPerStore = max(e.maxPerStore, e.active[store])
+	e.maxTotal = max(e.maxTotal, e.total)
+	e.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	e.mu.Lock()
+	e.active[store]--
+	e.total--
+	e.mu.Unlock()
+	return &borg.Result{}, nil
+}
+
+func TestRunPipelinesParallel(t *testing.T) {
+	setupEnv(t)
+	targets := []config.Target{}
+	for _, store := range []string{"usb", "nas"} {
+		for _, archive := range []string{"laptop", "desktop"} {
+			target := newTarget(archive, store)
+			target.Store.Local = "/backup/" + store
+			targets = append(targets, target)
+		}
+	}
+	executor := &trackingExecutor{active: make(map[string]int)}
+
+	results := runPipelines(context.Background(), executor, targets, 4)
+
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: unexpected error %v", r.Target, r.Err)
+		}
+		if r.Target != targets[i].GetName() {
+			t.Errorf("result %d is for %s, want %s", i, r.Target, targets[i].GetName())
+		}
+	}
+	if executor.maxPerStore != 1 {
+		t.Errorf("targets sharing a store ran concurrently (max %d)", executor.maxPerStore)
+	}
+	if executor.maxTotal != 2 {
+		t.Errorf("expected both stores to run concurrently, max concurrency was %d", executor.maxTotal)
+	}
+}
+
+func TestInitialise(t *testing.T) {
+	tests := []struct {
+		name            string
+		initialised     bool
+		encryption      string
+		responses       map[string]borgtest.Response
+		want            [][]string
+		wantInitialised bool
+		wantErr         bool
+	}{
+		{
+			name:            "new repository",
+			encryption:      "keyfile-blake2",
+			want:            [][]string{{"init", "--encryption", "keyfile-blake2"}},
+			wantInitialised: true,
+		},
+		{
+			name:            "repokey encryption",
+			encryption:      "repokey",
+			want:            [][]string{{"init", "--encryption", "repokey"}},
+			wantInitialised: true,
+		},
+		{
+			name:            "already initialised",
+			initialised:     true,
+			encryption:      "keyfile-blake2",
+			want:            [][]string{},
+			wantInitialised: true,
+		},
+		{
+			name:       "failed init is not marked initialised",
+			encryption: "keyfile-blake2",
+			responses:  map[string]borgtest.Response{"init": {ExitCode: 2}},
+			want:       [][]string{{"init", "--encryption", "keyfile-blake2"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			target.Encryption = tt.encryption
+			if tt.initialised {
+				markInitialised(t, target)
+			}
+			executor := borgtest.New()
+			for subcommand, r := range tt.responses {
+				executor.Respond(subcommand, r)
+			}
+
+			err := Initialise(context.Background(), executor, []config.Target{target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Initialise() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+			if target.IsInitialised() != tt.wantInitialised {
+				t.Errorf("IsInitialised() = %v, want %v", target.IsInitialised(), tt.wantInitialised)
+			}
+			if _, err := os.Stat(target.GetPasswordFile()); err != nil {
+				t.Errorf("password file was not created: %v", err)
+			}
+		})
+	}
+}
+
+// breakConfigExecutor replaces the config directory of a target with a file while borg initialises it,
+// so the target cannot be marked initialised
+type breakConfigExecutor struct {
+	*borgtest.Executor
+	target config.Target
+}
+
+func (e *breakConfigExecutor) Run(ctx context.Context, opts borg.Options, args ...string) (*borg.Result, error) {
+	if slices.Contains(opts.Env, "BORG_REPO="+e.target.GetBorgRepositoryPath()) {
+		if err := os.RemoveAll(e.target.GetConfigPath()); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(e.target.GetConfigPath(), nil, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return e.Executor.Run(ctx, opts, args...)
+}
+
+func TestInitialiseContinuesAfterMarkFailure(t *testing.T) {
+	setupEnv(t)
+	broken := newTarget("laptop", "usb")
+	other := newTarget("desktop", "usb")
+	executor := &breakConfigExecutor{Executor: borgtest.New(), target: broken}
+
+	err := Initialise(context.Background(), executor, []config.Target{broken, other})
+	if err == nil || !strings.HasPrefix(err.Error(), "laptop:usb: ") {
+		t.Fatalf("Initialise() error = %v, want a laptop:usb error", err)
+	}
+	assertArgs(t, executor.Args(), [][]string{
+		{"init", "--encryption", "keyfile-blake2"},
+		{"init", "--encryption", "keyfile-blake2"},
+	})
+	if broken.IsInitialised() {
+		t.Error("laptop:usb was marked initialised")
+	}
+	if !other.IsInitialised() {
+		t.Error("desktop:usb was not initialised after laptop:usb failed")
+	}
+}
+
+func TestInfoAndList(t *testing.T) {
+	tests := []struct {
+		name      string
+		run       func(*borgtest.Executor, []config.Target) error
+		responses map[string]borgtest.Response
+		want      [][]string
+		wantErr   bool
+	}{
+		{
+			name: "info text",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return Info(context.Background(), e, targets, "text")
+			},
+			want: [][]string{{"info"}},
+		},
+		{
+			name: "info json",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return Info(context.Background(), e, targets, "json")
+			},
+			responses: map[string]borgtest.Response{
+				"info": {Stdout: []string{`{"repository": {"id": "abc", "location": "/backup/laptop"}}`}},
+			},
+			want: [][]string{{"info", "--json"}},
+		},
+		{
+			name: "info unknown format",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return Info(context.Background(), e, targets, "xml")
+			},
+			want:    [][]string{},
+			wantErr: true,
+		},
+		{
+			name: "list text",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return List(context.Background(), e, targets, "text", false)
+			},
+			want: [][]string{{"list", "--glob-archives", "laptop-*"}},
+		},
+		{
+			name: "list all archives",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return List(context.Background(), e, targets, "text", true)
+			},
+			want: [][]string{{"list"}},
+		},
+		{
+			name: "list yaml",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return List(context.Background(), e, targets, "yaml", false)
+			},
+			responses: map[string]borgtest.Response{
+				"list": {Stdout: []string{`{"archives": [{"name": "laptop-1", "time": "2024-01-01T00:00:00.000000"}]}`}},
+			},
+			want: [][]string{{"list", "--json", "--glob-archives", "laptop-*"}},
+		},
+		{
+			name: "list invalid json",
+			run: func(e *borgtest.Executor, targets []config.Target) error {
+				return List(context.Background(), e, targets, "json", false)
+			},
+			responses: map[string]borgtest.Response{"list": {Stdout: []string{"not json"}}},
+			want:      [][]string{{"list", "--json", "--glob-archives", "laptop-*"}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			initialised := newTarget("laptop", "usb")
+			markInitialised(t, initialised)
+			uninitialised := newTarget("laptop", "ssh")
+			executor := borgtest.New()
+			for subcommand, r := range tt.responses {
+				executor.Respond(subcommand, r)
+			}
+
+			err := tt.run(executor, []config.Target{initialised, uninitialised})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+		})
+	}
+}
+
+func TestExportKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses map[string]borgtest.Response
+		want      func(target config.Target) [][]string
+		wantErr   bool
+	}{
+		{
+			name: "exports paper and binary keys",
+			want: func(target config.Target) [][]string {
+				return [][]string{
+					{"key", "export", "--paper", "::", target.GetPaperKeyfile()},
+					{"key", "export", "::", target.GetKeyfile()},
+				}
+			},
+		},
+		{
+			name:      "failed paper export",
+			responses: map[string]borgtest.Response{"key": {ExitCode: 2}},
+			want: func(target config.Target) [][]string {
+				return [][]string{
+					{"key", "export", "--paper", "::", target.GetPaperKeyfile()},
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			markInitialised(t, target)
+			executor := borgtest.New()
+			for subcommand, r := range tt.responses {
+				executor.Respond(subcommand, r)
+			}
+
+			err := ExportKey(context.Background(), executor, []config.Target{target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExportKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want(target))
+		})
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	tests := []struct {
+		name            string
+		paper           bool
+		passwordFile    string
+		responses       map[string]borgtest.Response
+		want            [][]string
+		wantStdin       bool
+		wantInitialised bool
+		wantErr         bool
+	}{
+		{
+			name:            "binary key",
+			want:            [][]string{{"key", "import", "::", "/keys/laptop.key"}, {"info"}},
+			wantInitialised: true,
+		},
+		{
+			name:            "paper key is read from stdin",
+			paper:           true,
+			want:            [][]string{{"key", "import", "--paper", "::"}, {"info"}},
+			wantStdin:       true,
+			wantInitialised: true,
+		},
+		{
+			name:            "password file is staged",
+			passwordFile:    "imported",
+			want:            [][]string{{"key", "import", "::", "/keys/laptop.key"}, {"info"}},
+			wantInitialised: true,
+		},
+		{
+			name:      "inaccessible repository is not marked initialised",
+			responses: map[string]borgtest.Response{"info": {ExitCode: 2}},
+			want:      [][]string{{"key", "import", "::", "/keys/laptop.key"}, {"info"}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+				t.Fatal(err)
+			}
+			passwordFile := ""
+			wantPassphrase := "secret"
+			if tt.passwordFile != "" {
+				passwordFile = filepath.Join(t.TempDir(), "password")
+				if err := os.WriteFile(passwordFile, []byte(tt.passwordFile), 0600); err != nil {
+					t.Fatal(err)
+				}
+				wantPassphrase = tt.passwordFile
+			} else if err := os.WriteFile(target.GetPasswordFile(), []byte("secret"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			executor := borgtest.New()
+			for subcommand, r := range tt.responses {
+				executor.Respond(subcommand, r)
+			}
+
+			err := ImportKey(context.Background(), executor, target, "/keys/laptop.key", passwordFile, tt.paper)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+			if stdin := executor.Calls[0].Stdin; (stdin != nil) != tt.wantStdin {
+				t.Errorf("key import stdin = %v, wantStdin %v", stdin, tt.wantStdin)
+			}
+			if target.IsInitialised() != tt.wantInitialised {
+				t.Errorf("IsInitialised() = %v, want %v", target.IsInitialised(), tt.wantInitialised)
+			}
+			if data, _ := os.ReadFile(target.GetPasswordFile()); string(data) != wantPassphrase {
+				t.Errorf("passphrase = %q, want %q", data, wantPassphrase)
+			}
+		})
+	}
+}
+
+func TestCreateNotifiesFailure(t *testing.T) {
+	setupEnv(t)
+	var got notify.Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	target := newTarget("laptop", "usb")
+	target.Compact = true
+	target.Notify = config.NotifyOptions{Webhook: &config.WebhookOptions{URL: server.URL}}
+	executor := borgtest.New().Respond("compact", borgtest.Response{ExitCode: 2, Stderr: []string{"Failed to create/acquire the lock"}})
+
+	if err := Create(context.Background(), executor, []config.Target{target}, 1); err == nil {
+		t.Fatal("expected compact to fail")
+	}
+	if got.Target != "laptop:usb" || got.Success || got.Step != "compact" || got.ExitCode != 2 {
+		t.Errorf("unexpected payload %+v", got)
+	}
+	if !slices.Equal(got.StderrTail, []string{"Failed to create/acquire the lock"}) {
+		t.Errorf("unexpected stderr tail %q", got.StderrTail)
+	}
+}
+
+// stagingExecutor records whether the staged passphrase exists when borg changes the key
+type stagingExecutor struct {
+	*borgtest.Executor
+	target config.Target
+	staged string
+}
+
+func (e *stagingExecutor) Run(ctx context.Context, opts borg.Options, args ...string) (*borg.Result, error) {
+	if data, err := os.ReadFile(e.target.GetNewPasswordFile()); err == nil {
+		e.staged = string(data)
+	}
+	return e.Executor.Run(ctx, opts, args...)
+}
+
+func TestRotatePassphrase(t *testing.T) {
+	tests := []struct {
+		name     string
+		response borgtest.Response
+		wantErr  bool
+	}{
+		{name: "success"},
+		{name: "borg failure keeps the old passphrase", response: borgtest.Response{ExitCode: 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			target.Passphrase.Length = 16
+			markInitialised(t, target)
+			executor := &stagingExecutor{Executor: borgtest.New(), target: target}
+			executor.Respond("key", tt.response)
+
+			err := RotatePassphrase(context.Background(), executor, []config.Target{target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(executor.staged) != 16 {
+				t.Errorf("new passphrase was not staged before borg ran, got %q", executor.staged)
+			}
+			if _, err := os.Stat(target.GetNewPasswordFile()); !os.IsNotExist(err) {
+				t.Errorf("staged passphrase was left behind: %v", err)
+			}
+			data, _ := os.ReadFile(target.GetPasswordFile())
+			want := "secret"
+			if !tt.wantErr {
+				want = executor.staged
+			}
+			if string(data) != want {
+				t.Errorf("passphrase = %q, want %q", data, want)
+			}
+		})
+	}
+}
+
+func TestExtractResolvesArchive(t *testing.T) {
+	list := borgtest.Response{Stdout: []string{`{"archives": [
+		{"name": "2023-05-01T00:00:00", "time": "2023-05-01T00:00:00.000000"},
+		{"name": "laptop-2024-01-01", "time": "2024-01-01T00:00:00.000000"}
+	]}`}}
+	tests := []struct {
+		name    string
+		archive string
+		latest  bool
+		want    [][]string
+	}{
+		{
+			name:    "archive named before archive_name",
+			archive: "2023-05-01T00:00:00",
+			want:    [][]string{{"list", "--json"}, {"extract", "::2023-05-01T00:00:00"}},
+		},
+		{
+			name:   "latest archive of the target",
+			latest: true,
+			want:   [][]string{{"list", "--json", "--glob-archives", "laptop-*"}, {"extract", "::laptop-2024-01-01"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupEnv(t)
+			target := newTarget("laptop", "usb")
+			markInitialised(t, target)
+			executor := borgtest.New()
+			executor.Respond("list", list)
+
+			err := Extract(context.Background(), executor, target, tt.archive, tt.latest, nil, t.TempDir(), false, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertArgs(t, executor.Args(), tt.want)
+		})
+	}
+}
+
+func TestExtractDryRun(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	markInitialised(t, target)
+	executor := borgtest.New()
+	executor.Respond("list", borgtest.Response{Stdout: []string{`{"archives": [{"name": "laptop-1", "time": "2024-01-01T00:00:00.000000"}]}`}})
+	dest := filepath.Join(t.TempDir(), "restore")
+
+	if err := Extract(context.Background(), executor, target, "laptop-1", false, nil, dest, true, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertArgs(t, executor.Args(), [][]string{{"list", "--json"}, {"extract", "--dry-run", "--list", "::laptop-1"}})
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("dry run created the destination: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+)
+
+// ConfigCheck validates the configuration file at path and reports every problem found
+func ConfigCheck(path string) error {
+	cfg, err := config.ReadConfigFile(path)
+	var cfgErr *config.ConfigError
+	if errors.As(err, &cfgErr) {
+		for _, p := range cfgErr.Problems {
+			logger.Error("%s:%s", p.File, p)
+		}
+		return fmt.Errorf("%d problems found in %s", len(cfgErr.Problems), path)
+	} else if err != nil {
+		return err
+	}
+	logger.Info("%s is valid (%d targets)", path, len(cfg.TargetMap))
+	return nil
+}
+
+// ConfigSchema prints the JSON Schema of the configuration file
+func ConfigSchema() error {
+	schema, err := config.JSONSchema()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(schema)
+	return err
+}
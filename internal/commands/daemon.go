@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/schedule"
+)
+
+// daemonPollInterval bounds how long the daemon sleeps between checks.
+// Timers do not advance while the machine is suspended, so the wall clock is re-checked regularly to catch up on missed runs
+const daemonPollInterval = time.Minute
+
+// readLastRun returns the time the daemon last ran the target, or the zero time if it never has
+func readLastRun(target config.Target) (time.Time, error) {
+	data, err := os.ReadFile(target.GetLastRunFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+func writeLastRun(target config.Target, t time.Time) error {
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(target.GetLastRunFile(), []byte(t.Format(time.RFC3339)+"\n"), 0600)
+}
+
+// scheduledTarget is a target with a schedule and the time it is next due to run
+type scheduledTarget struct {
+	target   config.Target
+	schedule schedule.Schedule
+	due      time.Time
+}
+
+// loadSchedule returns every target which has a schedule configured.
+// Targets which have never run are first due at their next scheduled time after now.
+// Targets which missed one or more runs, for example while suspended, are due immediately and run once
+func loadSchedule(cfg config.Config, now time.Time) []*scheduledTarget {
+	scheduled := []*scheduledTarget{}
+	for _, name := range slices.Sorted(maps.Keys(cfg.TargetMap)) {
+		target := cfg.TargetMap[name]
+		if target.Schedule == "" {
+			continue
+		}
+		s, err := schedule.Parse(target.Schedule)
+		if err != nil {
+			logger.Error("%s: %s", target.GetName(), err)
+			continue
+		}
+		last, err := readLastRun(target)
+		if err != nil {
+			logger.Warn("%s: unable to read last run time: %s", target.GetName(), err)
+		}
+		if last.IsZero() {
+			last = now
+		}
+		st := &scheduledTarget{target: target, schedule: s, due: s.Next(last)}
+		if st.due.IsZero() {
+			logger.Warn("%s: schedule '%s' never matches", target.GetName(), target.Schedule)
+			continue
+		}
+		logger.Info("%s: next run at %s", target.GetName(), st.due.Format(time.DateTime))
+		scheduled = append(scheduled, st)
+	}
+	return scheduled
+}
+
+// Daemon runs the pipeline for each target according to its schedule until the context is cancelled.
+// On SIGHUP the configuration is read again from cfg.Path
+func Daemon(ctx context.Context, executor borg.Executor, cfg config.Config) error {
+	logger.Info("Running Daemon")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	scheduled := loadSchedule(cfg, time.Now())
+	if len(scheduled) == 0 {
+		logger.Warn("No targets have a schedule configured")
+	}
+
+	for {
+		now := time.Now()
+		due := []config.Target{}
+		wait := daemonPollInterval
+		for _, st := range scheduled {
+			if !now.Before(st.due) {
+				due = append(due, st.target)
+				continue
+			}
+			wait = min(wait, st.due.Sub(now))
+		}
+
+		if len(due) > 0 {
+			for _, result := range runPipelines(ctx, executor, due, cfg.Parallel) {
+				if result.Err != nil {
+					logger.Error("%s", result.Err)
+				}
+			}
+			if cfg.MetricsFile != "" {
+				if err := WriteMetrics(cfg.MetricsFile, AllTargets(cfg)); err != nil {
+					logger.Warn("Unable to write metrics: %s", err)
+				}
+			}
+			for _, st := range scheduled {
+				if now.Before(st.due) {
+					continue
+				}
+				// A failed run is retried at the next scheduled time rather than immediately
+				if err := writeLastRun(st.target, now); err != nil {
+					logger.Warn("%s: unable to record last run time: %s", st.target.GetName(), err)
+				}
+				st.due = st.schedule.Next(now)
+				logger.Info("%s: next run at %s", st.target.GetName(), st.due.Format(time.DateTime))
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-hup:
+			timer.Stop()
+			logger.Info("Reloading configuration from %s", cfg.Path)
+			newCfg, err := config.ReadConfigFile(cfg.Path)
+			if err != nil {
+				logger.Error("Unable to reload configuration, keeping the previous one: %s", err)
+				continue
+			}
+			cfg = newCfg
+			scheduled = loadSchedule(cfg, time.Now())
+		case <-timer.C:
+		}
+	}
+}
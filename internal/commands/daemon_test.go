@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func TestLoadSchedule(t *testing.T) {
+	setupEnv(t)
+	now := time.Date(2024, time.March, 15, 10, 30, 0, 0, time.UTC)
+
+	missed := newTarget("laptop", "usb")
+	missed.Schedule = "1h"
+	if err := writeLastRun(missed, now.Add(-3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	fresh := newTarget("desktop", "usb")
+	fresh.Schedule = "0 2 * * *"
+	unscheduled := newTarget("server", "usb")
+
+	cfg := config.Config{TargetMap: map[string]config.Target{
+		missed.GetName():      missed,
+		fresh.GetName():       fresh,
+		unscheduled.GetName(): unscheduled,
+	}}
+	scheduled := loadSchedule(cfg, now)
+
+	if len(scheduled) != 2 {
+		t.Fatalf("expected 2 scheduled targets, got %d", len(scheduled))
+	}
+	want := map[string]time.Time{
+		missed.GetName(): now.Add(-2 * time.Hour),
+		fresh.GetName():  time.Date(2024, time.March, 16, 2, 0, 0, 0, time.UTC),
+	}
+	for _, st := range scheduled {
+		if !st.due.Equal(want[st.target.GetName()]) {
+			t.Errorf("%s: due %s, want %s", st.target.GetName(), st.due, want[st.target.GetName()])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/stream"
+)
+
+// hookEnvironment returns the environment of a hook, describing the target it runs for
+func hookEnvironment(target config.Target, event string, runErr error) []string {
+	env := append(os.Environ(),
+		"BORGDRONE_TARGET="+target.GetName(),
+		"BORGDRONE_ARCHIVE="+target.ArchiveName,
+		"BORGDRONE_STORE="+target.StoreName,
+		"BORGDRONE_REPO="+target.GetBorgRepositoryPath(),
+		"BORGDRONE_CONFIG_DIR="+target.GetConfigPath(),
+		"BORGDRONE_HOOK="+event,
+	)
+	if runErr != nil {
+		env = append(env, "BORGDRONE_ERROR="+runErr.Error())
+	}
+	return env
+}
+
+// runHook runs a single hook with `sh -c`, streaming its output through the logger.
+// The hook is stopped if it exceeds its timeout
+func runHook(ctx context.Context, target config.Target, event string, hook config.Hook, runErr error) error {
+	prefix := target.GetName() + " " + event
+	logger.Info("%s", logger.PrefixLine(prefix, hook.Command))
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = config.DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	command := stream.NewCmd("sh", "-c", hook.Command)
+	command.Env = hookEnvironment(target, event, runErr)
+
+	onStdout := func(line string) { logger.Info("%s", logger.PrefixLine(prefix, line)) }
+	onStderr := func(line string) { logger.Warn("%s", logger.PrefixLine(prefix, line)) }
+	status, err := stream.Run(ctx, command, nil, onStdout, onStderr)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s hook '%s' timed out after %s", event, hook.Command, timeout)
+	} else if err != nil {
+		return err
+	}
+
+	if status.Error != nil {
+		return fmt.Errorf("%s hook '%s' failed: %w", event, hook.Command, status.Error)
+	}
+	if status.Exit != 0 {
+		return fmt.Errorf("%s hook '%s' failed (exit code %d)", event, hook.Command, status.Exit)
+	}
+	return nil
+}
+
+// runHooks runs each hook in order. If stopOnError is set the first failure is returned immediately,
+// otherwise every hook is run and all failures are returned
+func runHooks(ctx context.Context, target config.Target, event string, hooks []config.Hook, runErr error, stopOnError bool) error {
+	errs := []error{}
+	for _, hook := range hooks {
+		if err := runHook(ctx, target, event, hook, runErr); err != nil {
+			if stopOnError {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
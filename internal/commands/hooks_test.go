@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg/borgtest"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+// logHook returns a hook which appends the event and target to the log file
+func logHook(log string) config.Hook {
+	return config.Hook{Command: `echo "$BORGDRONE_HOOK $BORGDRONE_TARGET $BORGDRONE_REPO" >> ` + log, Timeout: time.Minute}
+}
+
+func readLog(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestHooks(t *testing.T) {
+	setupEnv(t)
+	log := filepath.Join(t.TempDir(), "hooks.log")
+	target := newTarget("laptop", "usb")
+	target.Hooks = config.Hooks{
+		BeforeCreate: []config.Hook{logHook(log)},
+		AfterCreate:  []config.Hook{logHook(log)},
+		OnError:      []config.Hook{logHook(log)},
+		Finally:      []config.Hook{logHook(log)},
+	}
+	executor := borgtest.New()
+
+	if err := Create(context.Background(), executor, []config.Target{target}, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"before_create laptop:usb /backup/laptop",
+		"after_create laptop:usb /backup/laptop",
+		"finally laptop:usb /backup/laptop",
+	}
+	if got := readLog(t, log); !slices.Equal(got, want) {
+		t.Errorf("hooks ran %q, want %q", got, want)
+	}
+}
+
+func TestBeforeCreateHookAborts(t *testing.T) {
+	setupEnv(t)
+	log := filepath.Join(t.TempDir(), "hooks.log")
+	target := newTarget("laptop", "usb")
+	target.Hooks = config.Hooks{
+		BeforeCreate: []config.Hook{{Command: "exit 3", Timeout: time.Minute}, logHook(log)},
+		OnError:      []config.Hook{logHook(log)},
+		Finally:      []config.Hook{logHook(log)},
+	}
+	executor := borgtest.New()
+
+	err := Create(context.Background(), executor, []config.Target{target}, 1)
+	if err == nil || !strings.Contains(err.Error(), "before_create hook 'exit 3' failed (exit code 3)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(executor.Calls) != 0 {
+		t.Errorf("borg was run after a before_create hook failed: %q", executor.Args())
+	}
+	want := []string{"on_error laptop:usb /backup/laptop", "finally laptop:usb /backup/laptop"}
+	if got := readLog(t, log); !slices.Equal(got, want) {
+		t.Errorf("hooks ran %q, want %q", got, want)
+	}
+}
+
+func TestAfterCreateHookFailure(t *testing.T) {
+	setupEnv(t)
+	log := filepath.Join(t.TempDir(), "hooks.log")
+	target := newTarget("laptop", "usb")
+	target.Compact = true
+	target.Hooks = config.Hooks{
+		AfterCreate: []config.Hook{{Command: "exit 3", Timeout: time.Minute}, logHook(log)},
+		OnError:     []config.Hook{logHook(log)},
+		Finally:     []config.Hook{logHook(log)},
+	}
+	executor := borgtest.New()
+
+	result := runPipeline(context.Background(), executor, target)
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "after_create hook 'exit 3' failed (exit code 3)") {
+		t.Fatalf("unexpected error %v", result.Err)
+	}
+	if strings.Contains(result.Err.Error(), "laptop:usb: create:") {
+		t.Errorf("after_create failure was reported as a create failure: %v", result.Err)
+	}
+	if want := []StepStatus{StepOK, StepSkipped, StepOK, StepSkipped}; !slices.Equal(result.Steps, want) {
+		t.Errorf("steps = %q, want %q", result.Steps, want)
+	}
+	want := []string{
+		"after_create laptop:usb /backup/laptop",
+		"on_error laptop:usb /backup/laptop",
+		"finally laptop:usb /backup/laptop",
+	}
+	if got := readLog(t, log); !slices.Equal(got, want) {
+		t.Errorf("hooks ran %q, want %q", got, want)
+	}
+	status, err := readStatus(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastSuccess == nil || status.LastSuccess.Command != "create" || status.LastFailure != nil {
+		t.Errorf("expected create to be recorded as a success, got %+v", status)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	hook := config.Hook{Command: "sleep 10", Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	err := runHook(context.Background(), target, "before_create", hook, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("hook was not stopped at its timeout")
+	}
+}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+)
+
+// lockPollInterval is how often a held lock is retried while waiting for it
+const lockPollInterval = 250 * time.Millisecond
+
+// LockInfo describes the process holding a target lock. It is written to the lock file once the lock is taken
+type LockInfo struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// LockedError is returned when a target is locked by another borgdrone process.
+// The message does not name the target, as callers already prefix errors with it
+type LockedError struct {
+	Target string
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder.PID == 0 {
+		return "locked by another process"
+	}
+	return fmt.Sprintf("locked by PID %d (%s), running since %s. Use --wait to wait for it to finish",
+		e.Holder.PID, e.Holder.Command, e.Holder.Started.Format(time.DateTime))
+}
+
+type lockWaitKey struct{}
+
+// WithLockWait returns a context which makes target locks wait up to d for another process to release them
+func WithLockWait(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, lockWaitKey{}, d)
+}
+
+func lockWait(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(lockWaitKey{}).(time.Duration)
+	return d
+}
+
+// targetLock is an exclusive advisory lock on a target, held until Unlock is called
+type targetLock struct {
+	file *os.File
+}
+
+// lockTarget takes the lock for a target, waiting for as long as the context allows (see WithLockWait).
+// The kernel drops the lock if borgdrone dies, so a lock file left with the PID of a dead process is only reported
+func lockTarget(ctx context.Context, target config.Target) (*targetLock, error) {
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(target.GetLockFile(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockWait(ctx))
+	waiting := false
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			return nil, fmt.Errorf("unable to lock %s: %w", target.GetLockFile(), err)
+		}
+		if !time.Now().Before(deadline) {
+			holder, _ := readLockInfo(file)
+			file.Close()
+			return nil, &LockedError{Target: target.GetName(), Holder: holder}
+		}
+		if !waiting {
+			holder, _ := readLockInfo(file)
+			logger.Info("%s is locked by PID %d, waiting up to %s", target.GetName(), holder.PID, lockWait(ctx))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	if previous, err := readLockInfo(file); err == nil && previous.PID != 0 && !processExists(previous.PID) {
+		logger.Warn("%s: removing stale lock left by PID %d (%s)", target.GetName(), previous.PID, previous.Command)
+	}
+
+	info := LockInfo{PID: os.Getpid(), Command: strings.Join(os.Args, " "), Started: time.Now()}
+	data, err := json.Marshal(info)
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt(data, 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to write %s: %w", target.GetLockFile(), err)
+	}
+	return &targetLock{file: file}, nil
+}
+
+// Unlock clears the holder information and releases the lock.
+// The lock file itself is left in place, as removing it could let two processes lock different files
+func (l *targetLock) Unlock() {
+	l.file.Truncate(0)
+	l.file.Close()
+}
+
+// withTargetLock runs fn while holding the lock for target, and records the outcome as a run of command
+func withTargetLock(ctx context.Context, target config.Target, command string, fn func() error) error {
+	lock, err := lockTarget(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	record := RunRecord{Command: command, Start: time.Now()}
+	err = fn()
+	recordRun(target, record, err)
+	return err
+}
+
+func readLockInfo(file *os.File) (LockInfo, error) {
+	info := LockInfo{}
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil || len(data) == 0 {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// processExists returns false only if no process with the PID is running
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return !errors.Is(err, syscall.ESRCH)
+}
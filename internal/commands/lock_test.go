@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg/borgtest"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func TestLockTarget(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+
+	lock, err := lockTarget(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lockTarget(context.Background(), target)
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+	if lockedErr.Holder.PID != os.Getpid() {
+		t.Errorf("holder PID = %d, want %d", lockedErr.Holder.PID, os.Getpid())
+	}
+
+	// A waiting lock is taken once the holder releases it
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Unlock()
+	}()
+	ctx := WithLockWait(context.Background(), 5*time.Second)
+	waited, err := lockTarget(ctx, target)
+	if err != nil {
+		t.Fatalf("expected lock after waiting, got %v", err)
+	}
+	waited.Unlock()
+}
+
+func TestLockTargetStale(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// PIDs are limited to 2^22 on Linux, so this process cannot exist
+	data, _ := json.Marshal(LockInfo{PID: 1 << 30, Command: "borgdrone create"})
+	if err := os.WriteFile(target.GetLockFile(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var lock *targetLock
+	var err error
+	out := captureStdout(t, func() {
+		lock, err = lockTarget(context.Background(), target)
+	})
+	if err != nil {
+		t.Fatalf("stale lock was not taken over: %v", err)
+	}
+	lock.Unlock()
+	if !strings.Contains(out, "laptop:usb: removing stale lock left by PID 1073741824 (borgdrone create)") {
+		t.Errorf("stale lock was not reported, output %q", out)
+	}
+}
+
+func TestLockedErrorNamesTargetOnce(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	target.Prune = config.PruneOptions{KeepDaily: 7}
+	markInitialised(t, target)
+	lock, err := lockTarget(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	err = Prune(context.Background(), borgtest.New(), []config.Target{target}, false, false)
+	if err == nil {
+		t.Fatal("expected prune of a locked target to fail")
+	}
+	if got := err.Error(); strings.Count(got, "laptop:usb") != 1 || !strings.HasPrefix(got, "laptop:usb: locked by PID") {
+		t.Errorf("error = %q, want the target named once", got)
+	}
+}
+
+func TestInitialiseWaitsForConcurrentInit(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	lock, err := lockTarget(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another init holds the lock, and initialises the target before releasing it
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		markInitialised(t, target)
+		lock.Unlock()
+	}()
+
+	executor := borgtest.New()
+	ctx := WithLockWait(context.Background(), 5*time.Second)
+	if err := Initialise(ctx, executor, []config.Target{target}); err != nil {
+		t.Fatal(err)
+	}
+	assertArgs(t, executor.Args(), [][]string{})
+}
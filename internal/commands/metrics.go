@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+// metric is a single Prometheus gauge, with one sample per target
+type metric struct {
+	Name    string
+	Help    string
+	Samples map[string]float64
+}
+
+// metricLabel escapes a label value for the Prometheus text format
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatMetrics renders the gauges derived from the status of each target in the Prometheus text format.
+// Targets without a recorded run have no samples
+func formatMetrics(targets []config.Target) (string, error) {
+	metrics := []*metric{
+		{Name: "borgdrone_last_success_timestamp_seconds", Help: "Time the last successful backup finished"},
+		{Name: "borgdrone_last_run_timestamp_seconds", Help: "Time the last command finished"},
+		{Name: "borgdrone_last_exit_code", Help: "Exit code of the last backup pipeline"},
+		{Name: "borgdrone_run_duration_seconds", Help: "Duration of the last backup pipeline, from the start of create to the end of the last step"},
+		{Name: "borgdrone_archive_original_bytes", Help: "Original size of the last successful archive"},
+		{Name: "borgdrone_archive_compressed_bytes", Help: "Compressed size of the last successful archive"},
+		{Name: "borgdrone_deduplicated_bytes", Help: "Deduplicated size of the last successful archive"},
+		{Name: "borgdrone_archive_files", Help: "Number of files in the last successful archive"},
+		{Name: "borgdrone_repository_size_bytes", Help: "Compressed and deduplicated size of the repository"},
+	}
+	byName := make(map[string]*metric)
+	for _, m := range metrics {
+		m.Samples = make(map[string]float64)
+		byName[m.Name] = m
+	}
+	set := func(name string, target config.Target, value float64) {
+		byName[name].Samples[target.GetName()] = value
+	}
+
+	for _, target := range targets {
+		status, err := readStatus(target)
+		if err != nil {
+			return "", targetError(target, err)
+		}
+		if r := status.LastRun; r != nil {
+			set("borgdrone_last_run_timestamp_seconds", target, float64(r.End.Unix()))
+		}
+		if r := status.LastPipeline; r != nil {
+			set("borgdrone_last_exit_code", target, float64(r.ExitCode))
+			set("borgdrone_run_duration_seconds", target, r.End.Sub(r.Start).Seconds())
+		}
+		if s := status.LastSuccess; s != nil {
+			set("borgdrone_last_success_timestamp_seconds", target, float64(s.End.Unix()))
+			if s.Archive != nil {
+				set("borgdrone_archive_original_bytes", target, float64(s.Archive.Stats.OriginalSize))
+				set("borgdrone_archive_compressed_bytes", target, float64(s.Archive.Stats.CompressedSize))
+				set("borgdrone_deduplicated_bytes", target, float64(s.Archive.Stats.DeduplicatedSize))
+				set("borgdrone_archive_files", target, float64(s.Archive.Stats.NFiles))
+			}
+			if s.Cache != nil {
+				set("borgdrone_repository_size_bytes", target, float64(s.Cache.UniqueCsize))
+			}
+		}
+	}
+
+	b := &strings.Builder{}
+	for _, m := range metrics {
+		fmt.Fprintf(b, "# HELP %s %s\n", m.Name, m.Help)
+		fmt.Fprintf(b, "# TYPE %s gauge\n", m.Name)
+		for _, target := range targets {
+			if value, ok := m.Samples[target.GetName()]; ok {
+				fmt.Fprintf(b, "%s{target=\"%s\"} %g\n", m.Name, metricLabel(target.GetName()), value)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// AllTargets returns every configured target in name order for WriteMetrics.
+// Unlike config.GetTargets, a configuration without targets is not an error
+func AllTargets(cfg config.Config) []config.Target {
+	return slices.SortedFunc(maps.Values(cfg.TargetMap), func(a, b config.Target) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+}
+
+// WriteMetrics writes the metrics of every target to path for the node_exporter textfile collector.
+// The file is replaced atomically, so a partially written file is never collected
+func WriteMetrics(path string, targets []config.Target) error {
+	data, err := formatMetrics(targets)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	never := newTarget("desktop", "usb")
+	end := time.Unix(1700000000, 0)
+	record := RunRecord{
+		Command: "create",
+		Start:   end.Add(-90 * time.Second),
+		End:     end,
+		Archive: &borg.ArchiveInfo{Stats: borg.ArchiveStats{OriginalSize: 4096, DeduplicatedSize: 1024, NFiles: 10}},
+		Cache:   &borg.CacheStats{UniqueCsize: 123456},
+	}
+	// The last command is a short upload, while the whole pipeline took 5 minutes and failed
+	upload := RunRecord{Command: "upload", Start: end.Add(-10 * time.Second), End: end}
+	pipeline := RunRecord{Command: "pipeline", Start: end.Add(-5 * time.Minute), End: end, ExitCode: 2}
+	status := TargetStatus{LastRun: &upload, LastSuccess: &record, LastPipeline: &pipeline}
+	if err := writeStatus(target, status); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "borgdrone.prom")
+	if err := WriteMetrics(path, []config.Target{target, never}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE borgdrone_last_success_timestamp_seconds gauge\n",
+		`borgdrone_last_success_timestamp_seconds{target="laptop:usb"} 1.7e+09` + "\n",
+		`borgdrone_archive_original_bytes{target="laptop:usb"} 4096` + "\n",
+		`borgdrone_deduplicated_bytes{target="laptop:usb"} 1024` + "\n",
+		`borgdrone_run_duration_seconds{target="laptop:usb"} 300` + "\n",
+		`borgdrone_last_exit_code{target="laptop:usb"} 2` + "\n",
+		`borgdrone_repository_size_bytes{target="laptop:usb"} 123456` + "\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "desktop:usb") {
+		t.Errorf("target without a recorded run should have no samples:\n%s", data)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the metrics file in %s, found %d entries", dir, len(entries))
+	}
+}
+
+func TestAllTargets(t *testing.T) {
+	if targets := AllTargets(config.Config{}); len(targets) != 0 {
+		t.Errorf("AllTargets() = %v for a configuration without targets", targets)
+	}
+	cfg := config.Config{TargetMap: map[string]config.Target{
+		"laptop:usb":  newTarget("laptop", "usb"),
+		"desktop:usb": newTarget("desktop", "usb"),
+	}}
+	targets := AllTargets(cfg)
+	if len(targets) != 2 || targets[0].GetName() != "desktop:usb" || targets[1].GetName() != "laptop:usb" {
+		t.Errorf("AllTargets() = %v, want both targets in name order", targets)
+	}
+}
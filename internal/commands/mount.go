@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+)
+
+// MountRecord describes an active `borg mount` of a target
+type MountRecord struct {
+	Mountpoint string `json:"mountpoint"`
+	Archive    string `json:"archive,omitempty"`
+}
+
+func readMounts(target config.Target) ([]MountRecord, error) {
+	mounts := []MountRecord{}
+	data, err := os.ReadFile(target.GetMountsFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return mounts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &mounts)
+	return mounts, err
+}
+
+func writeMounts(target config.Target, mounts []MountRecord) error {
+	if len(mounts) == 0 {
+		err := os.Remove(target.GetMountsFile())
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(mounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(target.GetMountsFile(), data, 0600)
+}
+
+// isMounted checks whether the path is currently an active mountpoint.
+// If the mount table cannot be read, the path is assumed to still be mounted
+func isMounted(mountpoint string) bool {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == mountpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// activeMounts returns the tracked mounts for a target, discarding any which are no longer mounted
+func activeMounts(target config.Target) []MountRecord {
+	mounts, err := readMounts(target)
+	if err != nil {
+		logger.Warn("%s: unable to read %s: %s", target.GetName(), target.GetMountsFile(), err)
+		return []MountRecord{}
+	}
+	active := slices.DeleteFunc(slices.Clone(mounts), func(m MountRecord) bool {
+		return !isMounted(m.Mountpoint)
+	})
+	if len(active) != len(mounts) {
+		if err := writeMounts(target, active); err != nil {
+			logger.Warn("%s: unable to update %s: %s", target.GetName(), target.GetMountsFile(), err)
+		}
+	}
+	return active
+}
+
+func Mount(ctx context.Context, executor borg.Executor, target config.Target, allTargets []config.Target, mountpoint string, archive string) error {
+	logger.Info("Running Mount")
+	if !target.IsInitialised() {
+		return fmt.Errorf("target '%s' has not been initialised", target.GetName())
+	}
+
+	mountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return fmt.Errorf("invalid mountpoint: %w", err)
+	}
+	for _, t := range allTargets {
+		for _, m := range activeMounts(t) {
+			if m.Mountpoint == mountpoint {
+				return fmt.Errorf("%s is already mounted at %s", t.GetName(), mountpoint)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(mountpoint, 0755); err != nil {
+		return fmt.Errorf("unable to create mountpoint: %w", err)
+	}
+
+	location := "::"
+	if archive != "" {
+		location += archive
+	}
+	argv := []string{"mount", location, mountpoint}
+	logger.Info("%+v", argv)
+	opts := borg.Options{Env: target.GetEnvironment()}
+	if _, err := runBorg(ctx, executor, opts, argv...); err != nil {
+		return targetError(target, err)
+	}
+
+	mounts := append(activeMounts(target), MountRecord{Mountpoint: mountpoint, Archive: archive})
+	if err := writeMounts(target, mounts); err != nil {
+		logger.Warn("%s: mounted but unable to record mount: %s", target.GetName(), err)
+	}
+	logger.Info("Mounted %s at %s", target.GetName(), mountpoint)
+	return nil
+}
+
+// Umount unmounts the tracked mount at mountpoint, or every tracked mount if all is true
+func Umount(ctx context.Context, executor borg.Executor, targets []config.Target, mountpoint string, all bool) error {
+	logger.Info("Running Umount")
+	if !all {
+		abs, err := filepath.Abs(mountpoint)
+		if err != nil {
+			return fmt.Errorf("invalid mountpoint: %w", err)
+		}
+		mountpoint = abs
+	}
+
+	errs := []error{}
+	found := false
+	for _, target := range targets {
+		remaining := []MountRecord{}
+		for _, m := range activeMounts(target) {
+			if !all && m.Mountpoint != mountpoint {
+				remaining = append(remaining, m)
+				continue
+			}
+			found = true
+			opts := borg.Options{Env: target.GetEnvironment()}
+			if _, err := runBorg(ctx, executor, opts, "umount", m.Mountpoint); err != nil {
+				errs = append(errs, targetError(target, err))
+				remaining = append(remaining, m)
+				continue
+			}
+			logger.Info("Unmounted %s from %s", target.GetName(), m.Mountpoint)
+		}
+		if err := writeMounts(target, remaining); err != nil {
+			logger.Warn("%s: unable to update %s: %s", target.GetName(), target.GetMountsFile(), err)
+		}
+	}
+
+	if !found && !all {
+		return fmt.Errorf("%s is not mounted by borgdrone", mountpoint)
+	}
+	return errors.Join(errs...)
+}
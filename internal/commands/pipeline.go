@@ -0,0 +1,235 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/notify"
+)
+
+type StepStatus string
+
+const (
+	StepOK      StepStatus = "ok"
+	StepFailed  StepStatus = "FAILED"
+	StepSkipped StepStatus = "skipped"
+	StepNotRun  StepStatus = "-"
+)
+
+// pipelineStep is a single stage of the backup pipeline.
+// Enabled decides whether the step applies to a target, Run executes it and may add details to the run record.
+// After is optional and runs once the step has succeeded and been recorded. Its failure is reported as a hook failure,
+// so it neither fails the step nor stops the steps which follow
+type pipelineStep struct {
+	Name    string
+	Enabled func(t config.Target) bool
+	Run     func(ctx context.Context, executor borg.Executor, t config.Target, record *RunRecord) error
+	After   func(ctx context.Context, t config.Target) error
+}
+
+// notifyTimeout bounds the time spent sending the notifications of a single target
+const notifyTimeout = 2 * time.Minute
+
+// pipeline lists every step in the order they are executed for each target
+var pipeline = []pipelineStep{
+	{
+		Name:    "create",
+		Enabled: func(t config.Target) bool { return true },
+		Run:     createWithHooks,
+		After: func(ctx context.Context, t config.Target) error {
+			return runHooks(ctx, t, "after_create", t.Hooks.AfterCreate, nil, false)
+		},
+	},
+	{
+		Name:    "prune",
+		Enabled: func(t config.Target) bool { return !t.Prune.IsEmpty() },
+		Run: func(ctx context.Context, executor borg.Executor, t config.Target, _ *RunRecord) error {
+			return prune(ctx, executor, t, false, false)
+		},
+	},
+	{
+		Name:    "compact",
+		Enabled: func(t config.Target) bool { return t.Compact },
+		Run: func(ctx context.Context, executor borg.Executor, t config.Target, _ *RunRecord) error {
+			return compact(ctx, executor, t)
+		},
+	},
+	{
+		Name:    "upload",
+		Enabled: func(t config.Target) bool { return t.RcloneUploadPath != "" },
+		Run: func(ctx context.Context, _ borg.Executor, t config.Target, _ *RunRecord) error {
+			return upload(ctx, t)
+		},
+	},
+}
+
+// PipelineResult holds the status of each pipeline step for a single target, in pipeline order.
+// Err is set to the error of the step which failed, if any
+type PipelineResult struct {
+	Target string
+	Steps  []StepStatus
+	Err    error
+}
+
+// runPipeline executes each enabled step for the target, stopping at the first failure
+func runPipeline(ctx context.Context, executor borg.Executor, target config.Target) PipelineResult {
+	result := PipelineResult{Target: target.GetName()}
+	lock, err := lockTarget(ctx, target)
+	if err != nil {
+		for range pipeline {
+			result.Steps = append(result.Steps, StepNotRun)
+		}
+		result.Err = fmt.Errorf("%s: %w", target.GetName(), err)
+		return result
+	}
+	defer lock.Unlock()
+
+	payload := notify.Payload{Target: target.GetName(), Success: true, Start: time.Now()}
+	hookErrs := []error{}
+	for _, step := range pipeline {
+		if result.Err != nil {
+			result.Steps = append(result.Steps, StepNotRun)
+			continue
+		}
+		if !step.Enabled(target) {
+			result.Steps = append(result.Steps, StepSkipped)
+			continue
+		}
+		record := RunRecord{Command: step.Name, Start: time.Now()}
+		err := step.Run(ctx, executor, target, &record)
+		recordRun(target, record, err)
+		payload.Step = step.Name
+		if record.Archive != nil {
+			payload.Stats = &record.Archive.Stats
+		}
+		if err != nil {
+			result.Steps = append(result.Steps, StepFailed)
+			result.Err = fmt.Errorf("%s: %s: %w", target.GetName(), step.Name, err)
+			payload.Success = false
+			payload.ExitCode = errorExitCode(err)
+			payload.Error = err.Error()
+			payload.StderrTail = notify.StderrTail(err)
+			continue
+		}
+		result.Steps = append(result.Steps, StepOK)
+		if step.After != nil {
+			hookErrs = append(hookErrs, step.After(ctx, target))
+		}
+	}
+
+	// Cleanup hooks must run even if borgdrone was interrupted, such as to restart services stopped by a before_create hook
+	hookCtx := context.WithoutCancel(ctx)
+	runErr := errors.Join(result.Err, errors.Join(hookErrs...))
+	if runErr != nil {
+		hookErrs = append(hookErrs, runHooks(hookCtx, target, "on_error", target.Hooks.OnError, runErr, false))
+	}
+	hookErrs = append(hookErrs, runHooks(hookCtx, target, "finally", target.Hooks.Finally, runErr, false))
+	if err := errors.Join(hookErrs...); err != nil {
+		if result.Err == nil {
+			payload.Success = false
+			payload.Step = "hooks"
+			payload.ExitCode = errorExitCode(err)
+			payload.Error = err.Error()
+		}
+		result.Err = errors.Join(result.Err, fmt.Errorf("%s: %w", target.GetName(), err))
+	}
+	payload.End = time.Now()
+	recordPipeline(target, RunRecord{Command: "pipeline", Start: payload.Start}, result.Err)
+
+	if !target.Notify.IsEmpty() {
+		// Notify even when interrupted, but do not let a slow notifier hold up the remaining targets forever
+		notifyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+		defer cancel()
+		if err := notify.Send(notifyCtx, target.Notify, payload); err != nil {
+			logger.Warn("%s: %s", target.GetName(), err)
+		}
+	}
+	return result
+}
+
+// createWithHooks runs the before_create hooks and then creates the archive.
+// A failing before_create hook aborts the backup. The after_create hooks run from the step's After function
+func createWithHooks(ctx context.Context, executor borg.Executor, target config.Target, record *RunRecord) error {
+	if err := runHooks(ctx, target, "before_create", target.Hooks.BeforeCreate, nil, true); err != nil {
+		return err
+	}
+	return create(ctx, executor, target, record)
+}
+
+// runPipelines executes the pipeline for every target using up to `parallel` concurrent workers.
+// Targets sharing a store are always run one after another, so a repository is never written to concurrently.
+// Results are returned in the same order as targets
+func runPipelines(ctx context.Context, executor borg.Executor, targets []config.Target, parallel int) []PipelineResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// Group target indexes by store, preserving the order in which stores are first seen
+	groups := [][]int{}
+	storeGroup := make(map[string]int)
+	for idx, target := range targets {
+		g, ok := storeGroup[target.StoreName]
+		if !ok {
+			g = len(groups)
+			storeGroup[target.StoreName] = g
+			groups = append(groups, []int{})
+		}
+		groups[g] = append(groups[g], idx)
+	}
+
+	results := make([]PipelineResult, len(targets))
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for range min(parallel, len(groups)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				for _, idx := range group {
+					logger.Info("----- %s -----", targets[idx].GetName())
+					results[idx] = runPipeline(ctx, executor, targets[idx])
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// Run executes the full backup pipeline for every target and prints a summary of each step.
+// The errors of every failed target are returned
+func Run(ctx context.Context, executor borg.Executor, targets []config.Target, parallel int) error {
+	logger.Info("Running Pipeline")
+	results := runPipelines(ctx, executor, targets, parallel)
+
+	errs := []error{}
+	logger.Info("")
+	w := tabwriter.NewWriter(logger.NewWriter(logger.LevelInfo), 1, 4, 4, ' ', 0)
+	header := []string{"TARGET"}
+	for _, step := range pipeline {
+		header = append(header, strings.ToUpper(step.Name))
+	}
+	fmt.Fprintf(w, "%s\n", strings.Join(header, "\t"))
+	for _, r := range results {
+		row := []string{r.Target}
+		for _, s := range r.Steps {
+			row = append(row, string(s))
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+		errs = append(errs, r.Err)
+	}
+	w.Flush()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+)
+
+// RunRecord is the outcome of a single command run against a target
+type RunRecord struct {
+	Command  string    `json:"command"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	// Archive and Cache are only set for create, from the output of `borg create --json`
+	Archive *borg.ArchiveInfo `json:"archive,omitempty"`
+	Cache   *borg.CacheStats  `json:"cache,omitempty"`
+}
+
+// TargetStatus is stored in the status file of each target
+type TargetStatus struct {
+	LastRun     *RunRecord `json:"last_run,omitempty"`
+	LastFailure *RunRecord `json:"last_failure,omitempty"`
+	// LastSuccess is the last create which succeeded, which is the newest usable backup
+	LastSuccess *RunRecord `json:"last_success,omitempty"`
+	// LastPipeline covers the last create, run or daemon backup from the start of create to the end of the last step and hook
+	LastPipeline *RunRecord `json:"last_pipeline,omitempty"`
+}
+
+func readStatus(target config.Target) (TargetStatus, error) {
+	status := TargetStatus{}
+	data, err := os.ReadFile(target.GetStatusFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return status, nil
+	} else if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(data, &status)
+	return status, err
+}
+
+func writeStatus(target config.Target, status TargetStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target.GetConfigPath(), 0700); err != nil {
+		return err
+	}
+	tmp := target.GetStatusFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target.GetStatusFile())
+}
+
+// errorExitCode returns the borg exit code of err, or 1 for any other error
+func errorExitCode(err error) int {
+	if err == nil {
+		return borg.ExitCodeSuccess
+	}
+	var exitErr *borg.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
+
+// completeRecord sets the end time and outcome of a record
+func completeRecord(record *RunRecord, err error) {
+	record.End = time.Now()
+	record.ExitCode = errorExitCode(err)
+	if err != nil {
+		record.Error = err.Error()
+	}
+}
+
+// updateStatus applies update to the status file of the target.
+// Failing to record the status is only logged, as the command itself has already finished
+func updateStatus(target config.Target, update func(status *TargetStatus)) {
+	status, readErr := readStatus(target)
+	if readErr != nil {
+		logger.Warn("%s: replacing unreadable status file: %s", target.GetName(), readErr)
+	}
+	update(&status)
+	if err := writeStatus(target, status); err != nil {
+		logger.Warn("%s: unable to record run status: %s", target.GetName(), err)
+	}
+}
+
+// recordRun completes the record with the result of a command and stores it in the status file of the target
+func recordRun(target config.Target, record RunRecord, err error) {
+	completeRecord(&record, err)
+	updateStatus(target, func(status *TargetStatus) {
+		status.LastRun = &record
+		if err != nil {
+			status.LastFailure = &record
+		} else if record.Command == "create" {
+			status.LastSuccess = &record
+		}
+	})
+}
+
+// recordPipeline completes the record of a whole backup pipeline and stores it in the status file of the target
+func recordPipeline(target config.Target, record RunRecord, err error) {
+	completeRecord(&record, err)
+	updateStatus(target, func(status *TargetStatus) {
+		status.LastPipeline = &record
+	})
+}
+
+// formatAge formats a duration in days, hours and minutes
+func formatAge(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// formatBytes formats a size using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Status prints the last successful backup and last failure of each target.
+// An error is returned if any target with a max_age has no successful backup within it
+func Status(targets []config.Target) error {
+	errs := []error{}
+	now := time.Now()
+	w := tabwriter.NewWriter(logger.NewWriter(logger.LevelInfo), 1, 4, 4, ' ', 0)
+	fmt.Fprintf(w, "TARGET\tLAST SUCCESS\tAGE\tLAST FAILURE\tREPO SIZE\tMAX AGE\n")
+	for _, target := range targets {
+		status, err := readStatus(target)
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+		}
+
+		row := []string{target.GetName(), "never", "-", "-", "-", "-"}
+		var age time.Duration
+		if s := status.LastSuccess; s != nil {
+			age = now.Sub(s.End)
+			row[1] = s.End.Format(time.DateTime)
+			row[2] = formatAge(age)
+			if s.Cache != nil {
+				row[4] = formatBytes(s.Cache.UniqueCsize)
+			}
+		}
+		if f := status.LastFailure; f != nil {
+			row[3] = fmt.Sprintf("%s (%s)", f.End.Format(time.DateTime), f.Command)
+		}
+		if target.MaxAge > 0 {
+			row[5] = formatAge(target.MaxAge)
+			if status.LastSuccess == nil {
+				errs = append(errs, targetError(target, errors.New("has never been backed up")))
+			} else if age > target.MaxAge {
+				errs = append(errs, targetError(target, fmt.Errorf("last backup is %s old, older than max_age %s", formatAge(age), formatAge(target.MaxAge))))
+			}
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+	}
+	w.Flush()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg/borgtest"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+const createJSON = `{
+  "archive": {"name": "laptop-2024", "stats": {"nfiles": 10, "original_size": 4096, "compressed_size": 2048, "deduplicated_size": 1024}},
+  "cache": {"stats": {"unique_csize": 123456}}
+}`
+
+func TestRunRecords(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	target.Compact = true
+	executor := borgtest.New().
+		Respond("create", borgtest.Response{Stdout: strings.Split(createJSON, "\n")}).
+		Respond("compact", borgtest.Response{ExitCode: 2})
+
+	if err := Create(context.Background(), executor, []config.Target{target}, 1); err == nil {
+		t.Fatal("expected compact to fail")
+	}
+
+	status, err := readStatus(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastSuccess == nil || status.LastSuccess.Command != "create" {
+		t.Fatalf("expected create to be the last success, got %+v", status.LastSuccess)
+	}
+	if status.LastSuccess.Archive.Name != "laptop-2024" || status.LastSuccess.Cache.UniqueCsize != 123456 {
+		t.Errorf("create statistics were not recorded: %+v", status.LastSuccess)
+	}
+	if status.LastFailure == nil || status.LastFailure.Command != "compact" || status.LastFailure.ExitCode != 2 {
+		t.Errorf("expected compact to be the last failure, got %+v", status.LastFailure)
+	}
+	if status.LastRun == nil || status.LastRun.Command != "compact" {
+		t.Errorf("expected compact to be the last run, got %+v", status.LastRun)
+	}
+}
+
+func TestStatusMaxAge(t *testing.T) {
+	setupEnv(t)
+	fresh := newTarget("laptop", "usb")
+	fresh.MaxAge = 24 * time.Hour
+	stale := newTarget("desktop", "usb")
+	stale.MaxAge = 24 * time.Hour
+	never := newTarget("server", "usb")
+
+	recordRun(fresh, RunRecord{Command: "create", Start: time.Now()}, nil)
+	if err := writeStatus(stale, TargetStatus{LastSuccess: &RunRecord{Command: "create", End: time.Now().Add(-48 * time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Status([]config.Target{fresh, never}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	err := Status([]config.Target{fresh, stale, never})
+	if err == nil || !strings.Contains(err.Error(), "desktop:usb") {
+		t.Errorf("expected desktop:usb to exceed max_age, got %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/schedule"
+)
+
+// SystemdOptions control the generated systemd units
+type SystemdOptions struct {
+	// User generates units for the user service manager rather than the system one
+	User bool
+	// Executable and ConfigFile are the absolute paths used in ExecStart
+	Executable string
+	ConfigFile string
+	Nice       int
+	// IOSchedulingClass is one of realtime, best-effort or idle
+	IOSchedulingClass string
+	// RunAs and ConfigHome set User= and XDG_CONFIG_HOME for system units, so the service runs as the user
+	// whose config directory holds the passphrases and keys of the targets
+	RunAs      string
+	ConfigHome string
+}
+
+// SystemdServiceUser returns the user which system units run as, and the XDG config home holding its borgdrone
+// config directory. If name is empty, the user invoking borgdrone is used, looking through sudo
+func SystemdServiceUser(name string) (string, string, error) {
+	if name == "" {
+		name = os.Getenv("SUDO_USER")
+	}
+	current, err := user.Current()
+	if err != nil {
+		return "", "", err
+	}
+	if name == "" || name == current.Username {
+		// The config directory of the current user, which respects $XDG_CONFIG_HOME
+		return current.Username, filepath.Dir(config.ConfigPath()), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Username, filepath.Join(u.HomeDir, ".config"), nil
+}
+
+// SystemdUnit is a single generated unit file
+type SystemdUnit struct {
+	Name     string
+	Contents string
+}
+
+// systemdUnitName returns the unit name for a target without its suffix.
+// The name matches the target config directory, which avoids ':' in unit names
+func systemdUnitName(target config.Target) string {
+	return "borgdrone-" + target.ArchiveName + "_" + target.StoreName
+}
+
+// systemdTimerLines converts a target schedule into [Timer] settings
+func systemdTimerLines(spec string) ([]string, error) {
+	s, err := schedule.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch s := s.(type) {
+	case schedule.Cron:
+		lines := []string{}
+		for _, event := range s.OnCalendar() {
+			lines = append(lines, "OnCalendar="+event)
+		}
+		return lines, nil
+	case schedule.Interval:
+		// Persistent= only applies to OnCalendar, so interval timers also fire when the timer is started
+		return []string{"OnActiveSec=1min", fmt.Sprintf("OnUnitActiveSec=%s", time.Duration(s))}, nil
+	default:
+		return nil, fmt.Errorf("unsupported schedule '%s'", spec)
+	}
+}
+
+// systemdUnits generates the .service and .timer unit for a target
+func systemdUnits(target config.Target, opts SystemdOptions) ([]SystemdUnit, error) {
+	timerLines, err := systemdTimerLines(target.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	name := systemdUnitName(target)
+
+	service := &strings.Builder{}
+	fmt.Fprintf(service, "[Unit]\n")
+	fmt.Fprintf(service, "Description=borgdrone backup of %s\n", target.GetName())
+	if target.StoreType == config.SSHStore {
+		fmt.Fprintf(service, "Wants=network-online.target\n")
+		fmt.Fprintf(service, "After=network-online.target\n")
+	}
+	fmt.Fprintf(service, "\n[Service]\n")
+	fmt.Fprintf(service, "Type=oneshot\n")
+	if !opts.User {
+		fmt.Fprintf(service, "User=%s\n", opts.RunAs)
+		fmt.Fprintf(service, "Environment=XDG_CONFIG_HOME=%s\n", opts.ConfigHome)
+	}
+	fmt.Fprintf(service, "ExecStart=%s --config-file %s run %s\n", opts.Executable, opts.ConfigFile, target.GetName())
+	fmt.Fprintf(service, "Nice=%d\n", opts.Nice)
+	fmt.Fprintf(service, "IOSchedulingClass=%s\n", opts.IOSchedulingClass)
+
+	timer := &strings.Builder{}
+	fmt.Fprintf(timer, "[Unit]\n")
+	fmt.Fprintf(timer, "Description=Scheduled borgdrone backup of %s\n", target.GetName())
+	fmt.Fprintf(timer, "\n[Timer]\n")
+	for _, line := range timerLines {
+		fmt.Fprintf(timer, "%s\n", line)
+	}
+	fmt.Fprintf(timer, "Persistent=true\n")
+	fmt.Fprintf(timer, "\n[Install]\n")
+	fmt.Fprintf(timer, "WantedBy=timers.target\n")
+
+	return []SystemdUnit{
+		{Name: name + ".service", Contents: service.String()},
+		{Name: name + ".timer", Contents: timer.String()},
+	}, nil
+}
+
+// systemdUnitDir returns the directory units are installed into
+func systemdUnitDir(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "systemd", "user"), nil
+}
+
+// systemctl runs systemctl, passing --user when managing user units
+func systemctl(ctx context.Context, user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	logger.Info("systemctl %s", strings.Join(args, " "))
+	output, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+	if len(output) > 0 {
+		logger.Info("%s", strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %w", args[0], err)
+	}
+	return nil
+}
+
+// SystemdGenerate writes a .service and .timer unit for every target with a schedule.
+// Units are printed unless install is set. systemctl is only run when enable is set
+func SystemdGenerate(ctx context.Context, targets []config.Target, opts SystemdOptions, install bool, enable bool) error {
+	errs := []error{}
+	units := []SystemdUnit{}
+	timers := []string{}
+	for _, target := range targets {
+		if target.Schedule == "" {
+			logger.Warn("target '%s' has no schedule configured", target.GetName())
+			continue
+		}
+		targetUnits, err := systemdUnits(target, opts)
+		if err != nil {
+			errs = append(errs, targetError(target, err))
+			continue
+		}
+		units = append(units, targetUnits...)
+		timers = append(timers, systemdUnitName(target)+".timer")
+	}
+	if len(units) == 0 && len(errs) == 0 {
+		return errors.New("no targets have a schedule configured")
+	}
+
+	if !install {
+		for _, unit := range units {
+			fmt.Printf("# %s\n%s\n", unit.Name, unit.Contents)
+		}
+		return errors.Join(errs...)
+	}
+
+	dir, err := systemdUnitDir(opts.User)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		path := filepath.Join(dir, unit.Name)
+		if err := os.WriteFile(path, []byte(unit.Contents), 0644); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		logger.Info("Installed %s", path)
+	}
+
+	if !enable {
+		if len(timers) > 0 {
+			logger.Info("Enable the timers with: systemctl %sdaemon-reload && systemctl %senable --now %s",
+				userFlag(opts.User), userFlag(opts.User), strings.Join(timers, " "))
+		}
+		return errors.Join(errs...)
+	}
+	if err := systemctl(ctx, opts.User, "daemon-reload"); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if len(timers) > 0 {
+		if err := systemctl(ctx, opts.User, append([]string{"enable", "--now"}, timers...)...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func userFlag(user bool) string {
+	if user {
+		return "--user "
+	}
+	return ""
+}
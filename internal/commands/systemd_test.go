@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func TestSystemdUnits(t *testing.T) {
+	target := newTarget("laptop", "usb")
+	target.Schedule = "30 2 * * 1-5"
+	opts := SystemdOptions{
+		Executable:        "/usr/bin/borgdrone",
+		ConfigFile:        "/etc/borgdrone.yml",
+		Nice:              10,
+		IOSchedulingClass: "idle",
+		RunAs:             "lucy",
+		ConfigHome:        "/home/lucy/.config",
+	}
+
+	units, err := systemdUnits(target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || units[0].Name != "borgdrone-laptop_usb.service" || units[1].Name != "borgdrone-laptop_usb.timer" {
+		t.Fatalf("unexpected units %+v", units)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/bin/borgdrone --config-file /etc/borgdrone.yml run laptop:usb\n",
+		"Nice=10\n",
+		"IOSchedulingClass=idle\n",
+		"User=lucy\n",
+		"Environment=XDG_CONFIG_HOME=/home/lucy/.config\n",
+	} {
+		if !strings.Contains(units[0].Contents, want) {
+			t.Errorf("service does not contain %q:\n%s", want, units[0].Contents)
+		}
+	}
+
+	opts.User = true
+	units, err = systemdUnits(target, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(units[0].Contents, "User=") {
+		t.Errorf("user service should not set User=:\n%s", units[0].Contents)
+	}
+	for _, want := range []string{"OnCalendar=Mon,Tue,Wed,Thu,Fri *-*-* 02:30:00\n", "Persistent=true\n"} {
+		if !strings.Contains(units[1].Contents, want) {
+			t.Errorf("timer does not contain %q:\n%s", want, units[1].Contents)
+		}
+	}
+}
+
+func TestSystemdGenerateInstall(t *testing.T) {
+	setupEnv(t)
+	target := newTarget("laptop", "usb")
+	target.Schedule = "6h"
+	unscheduled := newTarget("desktop", "usb")
+	opts := SystemdOptions{User: true, Executable: "/usr/bin/borgdrone", ConfigFile: "/etc/borgdrone.yml", IOSchedulingClass: "idle"}
+
+	if err := SystemdGenerate(context.Background(), []config.Target{target, unscheduled}, opts, true, false); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "systemd", "user")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 installed units, got %d", len(entries))
+	}
+	timer, err := os.ReadFile(filepath.Join(dir, "borgdrone-laptop_usb.timer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(timer), "OnUnitActiveSec=6h0m0s\n") {
+		t.Errorf("unexpected timer:\n%s", timer)
+	}
+}
+
+func TestSystemdServiceUser(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/srv/config")
+	t.Setenv("SUDO_USER", "")
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, configHome, err := SystemdServiceUser("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != current.Username || configHome != "/srv/config" {
+		t.Errorf("service user = %s, %s, want %s, /srv/config", name, configHome, current.Username)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"cmp"
 	_ "embed"
 	"errors"
 	"fmt"
@@ -9,60 +10,112 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"codeberg.org/jstover/borgdrone/internal/schedule"
 	"gopkg.in/yaml.v3"
 )
 
 // ConfigYaml is the struct used for parsing the YAML configuration file
 type ConfigYaml struct {
+	// Include lists further files to merge into the configuration, as paths or globs relative to the main file
+	Include []string
+	// Parallel is the default number of targets processed concurrently by create and run
+	Parallel int
+	// MaxAge is the default for targets which do not set their own max_age
+	MaxAge string `yaml:"max_age"`
+	// MetricsFile is the .prom file written for the node_exporter textfile collector after each run
+	MetricsFile string `yaml:"metrics_file"`
+	// Notify configures the notifications sent for every target
+	Notify NotifyOptions
+
 	Stores struct {
-		Filesystem map[string]string
+		Filesystem map[string]FilesystemStoreYaml
 
 		Ssh map[string]struct {
-			Hostname string
-			Username string
-			Port     int
-			Path     string
-			SshKey   string `yaml:"ssh_key"`
+			Hostname   string `schema:"required"`
+			Username   string
+			Port       int
+			Path       string
+			SshKey     string `yaml:"ssh_key"`
+			Passphrase PassphraseOptions
 		}
 	}
 
 	Archives map[string]struct {
-		Include []string
-		Exclude []string
+		Include     []string
+		Exclude     []string
+		ArchiveName string `yaml:"archive_name"`
+		Hooks       `yaml:",inline"`
 	}
 
 	Targets []struct {
-		Archive       string
-		Store         string
+		Archive       string `schema:"required"`
+		Store         string `schema:"required"`
 		Encryption    string
-		Compresion    string
+		Compression   string
 		Compact       bool
 		OneFileSystem bool `yaml:"one_file_system"`
-		Prune        struct {
+		Prune         struct {
 			KeepDaily   int `yaml:"keep_daily"`
 			KeepWeekly  int `yaml:"keep_weekly"`
 			KeepMonthly int `yaml:"keep_monthly"`
 			KeepYearly  int `yaml:"keep_yearly"`
 		}
 		RcloneUploadPath string `yaml:"rclone_upload_path"`
+		Passphrase       PassphraseOptions
+		Schedule         string
+		MaxAge           string `yaml:"max_age"`
+		ArchiveName      string `yaml:"archive_name"`
+		Hooks            `yaml:",inline"`
+	}
+}
+
+// FilesystemStoreYaml is a filesystem store entry, which may either be a plain path or a mapping with additional options
+type FilesystemStoreYaml struct {
+	Path       string `schema:"required"`
+	Passphrase PassphraseOptions
+}
+
+func (FilesystemStoreYaml) yamlSchema() *Schema {
+	return &Schema{OneOf: []*Schema{{Type: "string"}, structSchema(reflect.TypeOf(FilesystemStoreYaml{}))}}
+}
+
+// UnmarshalYAML accepts both `name: /path` and `name: {path: /path, ...}` forms
+func (s *FilesystemStoreYaml) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Path)
 	}
+	type plain FilesystemStoreYaml
+	return node.Decode((*plain)(s))
 }
 
 // GetTarget reads a target configuration by its positional index and returns a Target object
 func (cfg ConfigYaml) GetTarget(idx int) Target {
 	target := cfg.Targets[idx]
 	t := Target{
-		StoreName:        target.Store,
-		ArchiveName:      target.Archive,
-		Archive:          Archive(cfg.Archives[target.Archive]),
+		StoreName:   target.Store,
+		ArchiveName: target.Archive,
+		Archive: Archive{
+			Include: cfg.Archives[target.Archive].Include,
+			Exclude: cfg.Archives[target.Archive].Exclude,
+		},
 		Encryption:       target.Encryption,
-		Compression:      target.Compresion,
+		Compression:      target.Compression,
 		Compact:          target.Compact,
-		OneFileSystem:     target.OneFileSystem,
+		OneFileSystem:    target.OneFileSystem,
 		Prune:            PruneOptions(target.Prune),
 		RcloneUploadPath: target.RcloneUploadPath,
+		Schedule:         target.Schedule,
+		// The archive name template of the target takes precedence over that of the archive
+		ArchiveNameTemplate: cmp.Or(target.ArchiveName, cfg.Archives[target.Archive].ArchiveName, DefaultArchiveNameTemplate),
+		Notify:              cfg.Notify,
+		// Archive hooks run before those of the target
+		Hooks: cfg.Archives[target.Archive].Hooks.merge(target.Hooks),
 	}
 	if t.Encryption == "" {
 		t.Encryption = "keyfile-blake2"
@@ -74,7 +127,8 @@ func (cfg ConfigYaml) GetTarget(idx int) Target {
 	// Populate the appropriate Store and set StoreType
 	if store, ok := cfg.Stores.Filesystem[t.StoreName]; ok {
 		t.StoreType = LocalStore
-		t.Store.Local = store
+		t.Store.Local = store.Path
+		t.Passphrase = store.Passphrase
 	} else if store, ok := cfg.Stores.Ssh[t.StoreName]; ok {
 		t.StoreType = SSHStore
 		t.Store.SSH = &SshStore{
@@ -86,8 +140,12 @@ func (cfg ConfigYaml) GetTarget(idx int) Target {
 		if t.Store.SSH.Port == 0 {
 			t.Store.SSH.Port = 22
 		}
+		t.Passphrase = store.Passphrase
 	}
 
+	// Target passphrase options take precedence over those of the store
+	t.Passphrase = t.Passphrase.merge(target.Passphrase)
+
 	// Ensure uninitialised slices are not nil. Workaround for json serialising empty slices as null
 	if len(t.Archive.Include) == 0 {
 		t.Archive.Include = []string{}
@@ -99,17 +157,25 @@ func (cfg ConfigYaml) GetTarget(idx int) Target {
 	return t
 }
 
-// Config is the main configuration struct which is passed into subcommands
-// Currently only contains the map of valid targets, but could be used for global program configuration
+// Config is the validated configuration which is passed into subcommands.
+// It holds the targets by name along with the global settings which apply to all of them
 type Config struct {
+	// TargetMap holds every target, keyed by its ARCHIVE:STORE name
 	TargetMap map[string]Target
+	// Parallel is the number of targets processed concurrently by create and run, at least 1
+	Parallel int
+	// MetricsFile is the Prometheus textfile written after each run, if set
+	MetricsFile string
+	// Path is the file the configuration was read from, used to reload it
+	Path string
 }
 
 // GetTargets returns an array of target objects matching the provided target spec
 func (cfg Config) GetTargets(archive string, store string) []Target {
 	targets := []Target{}
 
-	for _, t := range cfg.TargetMap {
+	for _, name := range slices.Sorted(maps.Keys(cfg.TargetMap)) {
+		t := cfg.TargetMap[name]
 		if t.ArchiveName == archive || archive == "" {
 			if t.StoreName == store || store == "" {
 				targets = append(targets, t)
@@ -125,67 +191,152 @@ func (cfg Config) GetTargets(archive string, store string) []Target {
 //go:embed default.yml
 var defaultConfigData []byte
 
+// ReadConfigFile reads and validates a configuration file, merging in the files it includes and those in its drop-in directory.
+// If the configuration is invalid, a *ConfigError listing every problem found is returned.
+// A file which cannot be parsed or holds a value of the wrong type stops the checks early, so only the syntax and schema
+// problems of the files are listed
 func ReadConfigFile(path string) (Config, error) {
-	var cfg ConfigYaml
-	data, err := os.ReadFile(path)
+	files, err := loadConfigFiles(path)
 	if err != nil {
 		return Config{}, err
 	}
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		return Config{}, err
+	if files.fatal() {
+		// A file could not be parsed, or holds values of the wrong type. The remaining checks would only report problems
+		// caused by the values which could not be decoded, so the problems found so far are returned on their own
+		return Config{}, &ConfigError{Path: path, Problems: files.problems()}
 	}
+	files.merge()
+	cfg, p := files.merged, files
 
-	localStores := slices.Collect(maps.Keys(cfg.Stores.Filesystem))
-	sshStores := slices.Collect(maps.Keys(cfg.Stores.Ssh))
-	allStores := slices.Concat(localStores, sshStores)
+	if cfg.Parallel < 0 {
+		p.addf([]any{"parallel"}, "parallel must not be negative")
+	}
+	if cfg.Parallel == 0 {
+		cfg.Parallel = 1
+	}
+
+	cfg.Notify.validate(p)
 
 	// Validate Stores
-	storeKeys := make(map[string]struct{})
-	for _, name := range allStores {
-		if _, has := storeKeys[name]; has {
-			return Config{}, fmt.Errorf("Invalid configuration: Duplicate Store name '%s' (%s)", name, path)
+	for name := range cfg.Stores.Ssh {
+		if _, ok := cfg.Stores.Filesystem[name]; ok {
+			p.addf([]any{"stores", "ssh", name}, "Duplicate Store name '%s', already defined in %s", name, files.defined["stores/filesystem/"+name].path)
+		}
+	}
+	// Missing values are reported with the same message and position as the schema, which reports them when absent
+	for name, store := range cfg.Stores.Filesystem {
+		if store.Path == "" {
+			p.addf([]any{"stores", "filesystem", name, "path"}, "missing required field 'path'")
+		}
+	}
+	for name, store := range cfg.Stores.Ssh {
+		if store.Hostname == "" {
+			p.addf([]any{"stores", "ssh", name, "hostname"}, "missing required field 'hostname'")
+		}
+	}
+	for name, archive := range cfg.Archives {
+		for _, hookPath := range archive.Hooks.missingCommands() {
+			p.addf(append([]any{"archives", name}, hookPath...), "missing required field 'command'")
 		}
-		storeKeys[name] = struct{}{}
 	}
 
 	// Validate Targets
-	for _, target := range cfg.Targets {
+	targets := make(map[string]Target)
+	// targetIdx holds the index of the first definition of each target, to report duplicates
+	targetIdx := make(map[string]int)
+	for idx, target := range cfg.Targets {
+		at := func(keys ...any) []any { return append([]any{"targets", idx}, keys...) }
 
-		// Check required values are present
+		// Check required values are present and references are valid
+		valid := true
 		if target.Archive == "" {
-			return Config{}, fmt.Errorf("Invalid Configuration: Target missing value: archive")
+			p.addf(at("archive"), "missing required field 'archive'")
+			valid = false
+		} else if _, ok := cfg.Archives[target.Archive]; !ok {
+			p.addf(at("archive"), "Invalid archive reference '%s'", target.Archive)
+			valid = false
 		}
+		_, isLocal := cfg.Stores.Filesystem[target.Store]
+		_, isSsh := cfg.Stores.Ssh[target.Store]
 		if target.Store == "" {
-			return Config{}, fmt.Errorf("Invalid Configuration: Target missing value: store")
+			p.addf(at("store"), "missing required field 'store'")
+			valid = false
+		} else if !isLocal && !isSsh {
+			p.addf(at("store"), "Invalid store reference '%s'", target.Store)
+			valid = false
 		}
 
-		// Check archive references
-		if _, ok := cfg.Archives[target.Archive]; !ok {
-			return Config{}, fmt.Errorf("Invalid configuration: Invalid archive reference '%s' (%s)", target.Archive, path)
+		for _, hookPath := range target.Hooks.missingCommands() {
+			p.addf(at(hookPath...), "missing required field 'command'")
 		}
 
-		// Check store references
-		if !slices.Contains(allStores, target.Store) {
-			return Config{}, fmt.Errorf("Invalid configuration: Invalid store reference '%s' (%s)", target.Store, path)
+		// rclone can only upload repositories which exist on the local filesystem
+		if isSsh && target.RcloneUploadPath != "" {
+			p.addf(at("rclone_upload_path"), "rclone_upload_path is not supported for SSH store '%s'", target.Store)
 		}
-	}
-
-	// Validate SSH Stores
-	for name, store := range cfg.Stores.Ssh {
-		if store.Hostname == "" {
-			return Config{}, fmt.Errorf("Invalid Configuration: SSH Store '%s' missing required value: hostname", name)
+		if !valid {
+			continue
 		}
-	}
 
-	// Generate map of Targets
-	targets := make(map[string]Target)
-	for idx := range cfg.Targets {
 		t := cfg.GetTarget(idx)
+		if first, ok := targetIdx[t.GetName()]; ok {
+			p.addf(at(), "Duplicate target '%s', already defined in %s", t.GetName(), files.targets[first].file.path)
+		} else {
+			targetIdx[t.GetName()] = idx
+		}
+
+		// Validate passphrase options once store and target values have been merged
+		if err := t.Passphrase.validate(); err != nil {
+			p.addf(at("passphrase"), "Target '%s': %s", t.GetName(), err)
+		} else if _, err := t.GetSecretProvider(); err != nil {
+			p.addf(at("passphrase"), "Target '%s': %s", t.GetName(), err)
+		}
+		if t.Schedule != "" {
+			if _, err := schedule.Parse(t.Schedule); err != nil {
+				p.addf(at("schedule"), "Target '%s': %s", t.GetName(), err)
+			}
+		}
+		if !strings.Contains(t.ArchiveNameTemplate, "{now") && !strings.Contains(t.ArchiveNameTemplate, "{utcnow") {
+			namePath := at("archive_name")
+			if target.ArchiveName == "" {
+				namePath = []any{"archives", target.Archive, "archive_name"}
+			}
+			p.addf(namePath, "Target '%s': archive_name must contain {now} or {utcnow} so archive names are unique", t.GetName())
+		}
+		maxAge, maxAgePath := target.MaxAge, at("max_age")
+		if maxAge == "" {
+			maxAge, maxAgePath = cfg.MaxAge, []any{"max_age"}
+		}
+		if maxAge != "" {
+			if t.MaxAge, err = parseMaxAge(maxAge); err != nil {
+				p.addf(maxAgePath, "Target '%s': %s", t.GetName(), err)
+			}
+		}
+
 		targets[t.GetName()] = t
 	}
 
-	return Config{TargetMap: targets}, nil
+	if problems := files.problems(); len(problems) > 0 {
+		return Config{}, &ConfigError{Path: path, Problems: problems}
+	}
+	return Config{TargetMap: targets, Parallel: cfg.Parallel, MetricsFile: cfg.MetricsFile, Path: path}, nil
+}
+
+// parseMaxAge reads a duration such as "36h", also accepting whole days such as "2d"
+func parseMaxAge(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_age '%s'", s)
+	}
+	return d, nil
 }
 
 func WriteDefaultConfigFile(path string) int {
@@ -0,0 +1,365 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "borgdrone.yml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfig(t, `
+stores:
+  filesystem:
+    usb: /backup/usb
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: usb
+    compression: zstd,3
+`)
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.TargetMap["laptop:usb"].Compression; got != "zstd,3" {
+		t.Errorf("compression = %q, want zstd,3", got)
+	}
+}
+
+func TestReadConfigFileArchiveName(t *testing.T) {
+	path := writeConfig(t, `
+stores:
+  filesystem:
+    usb: /backup/usb
+    nas: /backup/nas
+    cloud: /backup/cloud
+archives:
+  laptop:
+    include: [/data]
+    archive_name: 'work-{archive}-{now}'
+  desktop:
+    include: [/home]
+targets:
+  - archive: laptop
+    store: usb
+  - archive: laptop
+    store: nas
+    archive_name: '[{store}]-{utcnow}'
+  - archive: desktop
+    store: cloud
+`)
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		name   string
+		glob   string
+	}{
+		{target: "laptop:usb", name: "work-laptop-{now}", glob: "work-laptop-*"},
+		{target: "laptop:nas", name: "[nas]-{utcnow}", glob: "[[]nas]-*"},
+	}
+	for _, test := range tests {
+		target := cfg.TargetMap[test.target]
+		if got := target.GetArchiveName(); got != test.name {
+			t.Errorf("%s: archive name = %q, want %q", test.target, got, test.name)
+		}
+		if got := target.GetArchiveGlob(); got != test.glob {
+			t.Errorf("%s: archive glob = %q, want %q", test.target, got, test.glob)
+		}
+	}
+	if got := cfg.TargetMap["desktop:cloud"].ArchiveNameTemplate; got != DefaultArchiveNameTemplate {
+		t.Errorf("default archive name template = %q, want %q", got, DefaultArchiveNameTemplate)
+	}
+
+	path = writeConfig(t, `
+stores:
+  filesystem:
+    usb: /backup/usb
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: usb
+    archive_name: laptop
+`)
+	_, err = ReadConfigFile(path)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 ||
+		cfgErr.Problems[0].String() != "11:5: Target 'laptop:usb': archive_name must contain {now} or {utcnow} so archive names are unique" {
+		t.Errorf("expected an archive_name problem, got %v", err)
+	}
+}
+
+func TestReadConfigFileProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "schema",
+			config: `parallel: many
+stores:
+  filesystem:
+    usb:
+      path: /backup/usb
+      passphrase:
+        lenght: 3
+  ssh:
+    nas:
+      port: 22
+archives:
+  laptop:
+    paths: [/data]
+    before_create:
+      - timeout: 1m
+targets:
+  - archive: laptop
+    compresion: zstd
+`,
+			want: []string{
+				"1:11: expected integer, got string",
+				"7:9: unknown field 'lenght'",
+				"10:7: missing required field 'hostname'",
+				"13:5: unknown field 'paths'",
+				"15:9: missing required field 'command'",
+				"17:5: missing required field 'store'",
+				"18:5: unknown field 'compresion'",
+			},
+		},
+		{
+			name: "unknown fields do not stop later checks",
+			config: `stores:
+  filesystem:
+    usb: /backup/usb
+archives:
+  laptop:
+    include: [/data]
+    bogus: true
+targets:
+  - archive: laptop
+    store: nas
+  - archive: desktop
+    store: usb
+`,
+			want: []string{
+				"7:5: unknown field 'bogus'",
+				"10:5: Invalid store reference 'nas'",
+				"11:5: Invalid archive reference 'desktop'",
+			},
+		},
+		{
+			name: "missing fields are reported once",
+			config: `notify:
+  email:
+    host: smtp.example.com
+    to: [admin@example.com]
+stores:
+  ssh:
+    nas:
+      port: 22
+archives:
+  laptop:
+    include: [/data]
+    before_create:
+      - timeout: 1m
+targets:
+  - archive: laptop
+  - archive: desktop
+    store: nas
+    finally:
+      - command: ""
+`,
+			want: []string{
+				"3:5: missing required field 'from'",
+				"8:7: missing required field 'hostname'",
+				"13:9: missing required field 'command'",
+				"15:5: missing required field 'store'",
+				"16:5: Invalid archive reference 'desktop'",
+				"19:9: missing required field 'command'",
+			},
+		},
+		{
+			name: "decode",
+			config: `archives:
+  laptop:
+    before_create:
+      - command: true
+        timeout: banana
+`,
+			want: []string{
+				"5:18: invalid hook timeout `banana`",
+			},
+		},
+		{
+			name: "semantic",
+			config: `parallel: -1
+stores:
+  filesystem:
+    usb: /backup/usb
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: nas
+  - archive: desktop
+    store: usb
+`,
+			want: []string{
+				"1:1: parallel must not be negative",
+				"10:5: Invalid store reference 'nas'",
+				"11:5: Invalid archive reference 'desktop'",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadConfigFile(writeConfig(t, test.config))
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("expected a ConfigError, got %v", err)
+			}
+			got := []string{}
+			for _, p := range cfgErr.Problems {
+				got = append(got, p.String())
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("problems:\n got: %q\nwant: %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileIncludes(t *testing.T) {
+	path := writeConfig(t, `
+include: [shared/*.yml]
+parallel: 2
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: usb
+`)
+	dir := filepath.Dir(path)
+	files := map[string]string{
+		"shared/stores.yml": `
+stores:
+  filesystem:
+    usb: /backup/usb
+    nas: /backup/nas
+`,
+		"borgdrone.d/desktop.yml": `
+archives:
+  desktop:
+    include: [/home]
+targets:
+  - archive: desktop
+    store: nas
+`,
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Sorted(maps.Keys(cfg.TargetMap))
+	if want := []string{"desktop:nas", "laptop:usb"}; !slices.Equal(got, want) {
+		t.Errorf("targets = %q, want %q", got, want)
+	}
+	if cfg.Parallel != 2 {
+		t.Errorf("parallel = %d, want 2", cfg.Parallel)
+	}
+}
+
+func TestReadConfigFileIncludeProblems(t *testing.T) {
+	path := writeConfig(t, `parallel: 2
+stores:
+  filesystem:
+    nas: /backup/nas
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: nas
+`)
+	dropIn := filepath.Join(filepath.Dir(path), DropInDir, "laptop.yml")
+	if err := os.MkdirAll(filepath.Dir(dropIn), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dropIn, []byte(`parallel: 4
+archives:
+  laptop:
+    include: [/home]
+targets:
+  - archive: laptop
+    store: usb
+  - archive: laptop
+    store: nas
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadConfigFile(path)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+	got := []string{}
+	for _, p := range cfgErr.Problems {
+		got = append(got, p.File+":"+p.String())
+	}
+	want := []string{
+		dropIn + ":1:1: Duplicate setting 'parallel', already defined in " + path,
+		dropIn + ":3:3: Duplicate archive 'laptop', already defined in " + path,
+		dropIn + ":7:5: Invalid store reference 'usb'",
+		dropIn + ":8:5: Duplicate target 'laptop:nas', already defined in " + path,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("problems:\n got: %q\nwant: %q", got, want)
+	}
+
+	missing := writeConfig(t, "include: [missing.yml]\n")
+	_, err = ReadConfigFile(missing)
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 ||
+		cfgErr.Problems[0].String() != "1:11: Included file 'missing.yml' does not exist" {
+		t.Errorf("expected a missing include problem, got %v", err)
+	}
+}
+
+func TestReadConfigFileSyntaxError(t *testing.T) {
+	path := writeConfig(t, "stores:\n  filesystem: [\n")
+	_, err := ReadConfigFile(path)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || len(cfgErr.Problems) != 1 || cfgErr.Problems[0].Line == 0 {
+		t.Errorf("expected a positioned syntax error, got %v", err)
+	}
+}
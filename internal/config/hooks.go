@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultHookTimeout is used for hooks which do not set their own timeout
+const DefaultHookTimeout = 30 * time.Minute
+
+// Hook is a shell command run at a point of the backup pipeline
+type Hook struct {
+	Command string
+	Timeout time.Duration
+}
+
+// hookYaml is the mapping form of a hook
+type hookYaml struct {
+	Command string `schema:"required"`
+	Timeout string
+}
+
+func (Hook) yamlSchema() *Schema {
+	return &Schema{OneOf: []*Schema{{Type: "string"}, structSchema(reflect.TypeOf(hookYaml{}))}}
+}
+
+// UnmarshalYAML accepts both `- command` and `- {command: command, timeout: 10m}` forms
+func (h *Hook) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Timeout = DefaultHookTimeout
+		return node.Decode(&h.Command)
+	}
+	var r hookYaml
+	if err := node.Decode(&r); err != nil {
+		return err
+	}
+	h.Command = r.Command
+	h.Timeout = DefaultHookTimeout
+	if r.Timeout != "" {
+		timeout, err := time.ParseDuration(r.Timeout)
+		if err != nil || timeout <= 0 {
+			line := node.Line
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == "timeout" {
+					line = node.Content[i+1].Line
+				}
+			}
+			return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: invalid hook timeout `%s`", line, r.Timeout)}}
+		}
+		h.Timeout = timeout
+	}
+	return nil
+}
+
+// Hooks lists the commands run around the backup pipeline of a target.
+// before_create hooks run in order and abort the backup if any fails. after_create hooks run once the archive is created,
+// and their failure does not stop the steps which follow. on_error hooks run if any step or hook failed,
+// and finally hooks always run last
+type Hooks struct {
+	BeforeCreate []Hook `yaml:"before_create"`
+	AfterCreate  []Hook `yaml:"after_create"`
+	OnError      []Hook `yaml:"on_error"`
+	Finally      []Hook `yaml:"finally"`
+}
+
+// merge appends the hooks in other after those in h
+func (h Hooks) merge(other Hooks) Hooks {
+	return Hooks{
+		BeforeCreate: append(append([]Hook{}, h.BeforeCreate...), other.BeforeCreate...),
+		AfterCreate:  append(append([]Hook{}, h.AfterCreate...), other.AfterCreate...),
+		OnError:      append(append([]Hook{}, h.OnError...), other.OnError...),
+		Finally:      append(append([]Hook{}, h.Finally...), other.Finally...),
+	}
+}
+
+// missingCommands returns the paths of the hooks which have no command, relative to the mapping defining them
+func (h Hooks) missingCommands() [][]any {
+	events := []struct {
+		name  string
+		hooks []Hook
+	}{
+		{"before_create", h.BeforeCreate},
+		{"after_create", h.AfterCreate},
+		{"on_error", h.OnError},
+		{"finally", h.Finally},
+	}
+	paths := [][]any{}
+	for _, event := range events {
+		for i, hook := range event.hooks {
+			if hook.Command == "" {
+				paths = append(paths, []any{event.name, i, "command"})
+			}
+		}
+	}
+	return paths
+}
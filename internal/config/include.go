@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DropInDir is the directory next to the main configuration file whose *.yml files are merged into it
+const DropInDir = "borgdrone.d"
+
+// configFile is a single file of the configuration, either the main file or one merged into it
+type configFile struct {
+	path string
+	cfg  ConfigYaml
+	p    *problems
+}
+
+// targetSource locates a merged target in the file which defines it
+type targetSource struct {
+	file *configFile
+	idx  int
+}
+
+// configFiles merges the files of a configuration, remembering which file defines each value
+// so problems found in the merged configuration are reported in the right file.
+//
+// Maps (stores and archives) are merged and targets are concatenated in file order.
+// Defining the same store, archive or top-level setting in two files is a problem
+type configFiles struct {
+	files   []*configFile
+	merged  ConfigYaml
+	targets []targetSource
+	// defined maps a slash separated path such as "archives/laptop" to the file defining it
+	defined map[string]*configFile
+}
+
+// isGlob returns true if an include pattern contains glob metacharacters
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// loadConfigFiles reads the main configuration file, the files it includes and those in its drop-in directory.
+// Each file is validated against the schema and decoded on its own
+func loadConfigFiles(path string) (*configFiles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	main := &configFile{path: path}
+	main.cfg, main.p = decodeConfig(data)
+	c := &configFiles{files: []*configFile{main}, defined: make(map[string]*configFile)}
+	if main.p.fatal {
+		return c, nil
+	}
+
+	seen := map[string]bool{}
+	if abs, err := filepath.Abs(path); err == nil {
+		seen[abs] = true
+	}
+	add := func(path string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if seen[abs] {
+			return nil
+		}
+		seen[abs] = true
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f := &configFile{path: path}
+		f.cfg, f.p = decodeConfig(data)
+		if !f.p.fatal && len(f.cfg.Include) > 0 {
+			f.p.addf([]any{"include"}, "include is only supported in the main configuration file")
+		}
+		c.files = append(c.files, f)
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	for idx, pattern := range main.cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches := []string{pattern}
+		if isGlob(pattern) {
+			if matches, err = filepath.Glob(pattern); err != nil {
+				main.p.addf([]any{"include", idx}, "Invalid include pattern '%s'", main.cfg.Include[idx])
+				continue
+			}
+		}
+		for _, match := range matches {
+			if err := add(match); errors.Is(err, os.ErrNotExist) {
+				main.p.addf([]any{"include", idx}, "Included file '%s' does not exist", main.cfg.Include[idx])
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dropIns, err := filepath.Glob(filepath.Join(dir, DropInDir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range dropIns {
+		if err := add(match); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// merge combines the decoded files into c.merged, reporting values defined in more than one file
+func (c *configFiles) merge() {
+	c.merged = c.files[0].cfg
+	c.merged.Stores.Filesystem = nil
+	c.merged.Stores.Ssh = nil
+	c.merged.Archives = nil
+	c.merged.Targets = nil
+
+	for _, f := range c.files {
+		// Top-level settings, which may be set in a single file
+		root := f.p.lookup()
+		merged, value := reflect.ValueOf(&c.merged).Elem(), reflect.ValueOf(f.cfg)
+		for i := 0; i < value.NumField(); i++ {
+			name, _ := yamlFieldName(value.Type().Field(i))
+			if slices.Contains([]string{"stores", "archives", "targets", "include"}, name) || !hasKey(root, name) {
+				continue
+			}
+			if c.define(f, name, name, "setting") {
+				merged.Field(i).Set(value.Field(i))
+			}
+		}
+
+		for name, store := range f.cfg.Stores.Filesystem {
+			if c.define(f, "stores/filesystem/"+name, name, "filesystem store") {
+				setEntry(&c.merged.Stores.Filesystem, name, store)
+			}
+		}
+		for name, store := range f.cfg.Stores.Ssh {
+			if c.define(f, "stores/ssh/"+name, name, "SSH store") {
+				setEntry(&c.merged.Stores.Ssh, name, store)
+			}
+		}
+		for name, archive := range f.cfg.Archives {
+			if c.define(f, "archives/"+name, name, "archive") {
+				setEntry(&c.merged.Archives, name, archive)
+			}
+		}
+		for idx, target := range f.cfg.Targets {
+			c.merged.Targets = append(c.merged.Targets, target)
+			c.targets = append(c.targets, targetSource{file: f, idx: idx})
+		}
+	}
+}
+
+// setEntry sets a key of a map, creating the map if needed
+func setEntry[K comparable, V any](m *map[K]V, key K, value V) {
+	if *m == nil {
+		*m = make(map[K]V)
+	}
+	(*m)[key] = value
+}
+
+// hasKey returns true if a mapping node has the given key
+func hasKey(node *yaml.Node, key string) bool {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// define records that f defines the value at key, returning false and reporting a problem if another file already does
+func (c *configFiles) define(f *configFile, key string, name string, kind string) bool {
+	path := []any{}
+	for _, elem := range strings.Split(key, "/") {
+		path = append(path, elem)
+	}
+	if other, ok := c.defined[key]; ok {
+		f.p.addf(path, "Duplicate %s '%s', already defined in %s", kind, name, other.path)
+		return false
+	}
+	c.defined[key] = f
+	return true
+}
+
+// addf records a problem at a path of the merged configuration, in the file which defines it
+func (c *configFiles) addf(path []any, format string, a ...any) {
+	f := c.files[0]
+	if len(path) >= 2 && path[0] == "targets" {
+		if idx, ok := path[1].(int); ok && idx < len(c.targets) {
+			source := c.targets[idx]
+			f = source.file
+			path = append([]any{"targets", source.idx}, path[2:]...)
+		}
+	} else {
+		for n := len(path); n > 0; n-- {
+			key := []string{}
+			for _, elem := range path[:n] {
+				key = append(key, fmt.Sprint(elem))
+			}
+			if defined, ok := c.defined[strings.Join(key, "/")]; ok {
+				f = defined
+				break
+			}
+		}
+	}
+	f.p.addf(path, format, a...)
+}
+
+// fatal returns true if any file could not be decoded, so the merged configuration cannot be checked
+func (c *configFiles) fatal() bool {
+	return slices.ContainsFunc(c.files, func(f *configFile) bool { return f.p.fatal })
+}
+
+// problems returns every problem found, ordered by file and then by position
+func (c *configFiles) problems() []Problem {
+	list := []Problem{}
+	for _, f := range c.files {
+		for _, problem := range f.p.sorted() {
+			problem.File = f.path
+			list = append(list, problem)
+		}
+	}
+	return list
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolate expands `${VAR}` and `${VAR:-default}` references in s, using lookup to find the value of VAR.
+// `$$` is replaced with a single `$`, and a `$` not followed by `{` or `$` is kept as it is.
+// Referencing a variable which is not defined and has no default is an error
+func interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference '%s'", s[i:])
+			}
+			ref := s[i+2 : i+end]
+			name, def, hasDefault := strings.Cut(ref, ":-")
+			if name == "" {
+				return "", fmt.Errorf("invalid variable reference '${%s}'", ref)
+			}
+			value, ok := lookup(name)
+			if !ok || (hasDefault && value == "") {
+				if !hasDefault {
+					return "", fmt.Errorf("undefined variable '%s'", name)
+				}
+				value = def
+			}
+			b.WriteString(value)
+			s = s[i+end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+}
+
+// lookupVariable returns the value of an environment variable.
+// HOSTNAME is usually a shell variable which is not exported, so the system hostname is used if it is not set
+func lookupVariable(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if name == "HOSTNAME" {
+		if hostname, err := os.Hostname(); err == nil {
+			return hostname, true
+		}
+	}
+	return "", false
+}
+
+// shellKeys hold shell commands, whose values are never interpolated so they can use variables such as
+// ${BORGDRONE_TARGET}, which are set when the command runs
+var shellKeys = []string{"command", "commands"}
+
+// hookKeys hold lists of hooks, where each hook is either a shell command or a mapping with a command
+var hookKeys = []string{"before_create", "after_create", "on_error", "finally"}
+
+// interpolateNode expands variable references in every scalar value below node other than shell commands,
+// recording a problem for each failure.
+// Plain scalars are resolved again once expanded, so `port: ${SSH_PORT}` is still read as an integer
+func interpolateNode(node *yaml.Node, p *problems) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value, err := interpolate(node.Value, lookupVariable)
+		if err != nil {
+			p.list = append(p.list, Problem{Line: node.Line, Column: node.Column, Message: err.Error()})
+			return
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	case yaml.MappingNode:
+		// Keys are never interpolated
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			switch {
+			case slices.Contains(shellKeys, key):
+			case slices.Contains(hookKeys, key) && value.Kind == yaml.SequenceNode:
+				for _, hook := range value.Content {
+					if hook.Kind != yaml.ScalarNode {
+						interpolateNode(hook, p)
+					}
+				}
+			default:
+				interpolateNode(value, p)
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			interpolateNode(child, p)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import "testing"
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"HOME": "/home/lucy", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{in: "/backup", want: "/backup"},
+		{in: "${HOME}/Documents", want: "/home/lucy/Documents"},
+		{in: "${MISSING:-/srv}/data", want: "/srv/data"},
+		{in: "${EMPTY:-default}", want: "default"},
+		{in: "${EMPTY}", want: ""},
+		{in: "cost $$5 and $HOME", want: "cost $5 and $HOME"},
+		{in: "$${HOME}", want: "${HOME}"},
+		{in: "trailing $", want: "trailing $"},
+		{in: "${MISSING}", err: "undefined variable 'MISSING'"},
+		{in: "${HOME", err: "unterminated variable reference '${HOME'"},
+		{in: "${:-x}", err: "invalid variable reference '${:-x}'"},
+	}
+	for _, test := range tests {
+		got, err := interpolate(test.in, lookup)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("interpolate(%q) error = %v, want %q", test.in, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("interpolate(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestReadConfigFileInterpolation(t *testing.T) {
+	t.Setenv("BACKUP_ROOT", "/mnt/backup")
+	t.Setenv("SSH_PORT", "2222")
+	path := writeConfig(t, `
+stores:
+  filesystem:
+    usb: ${BACKUP_ROOT}/usb
+  ssh:
+    nas:
+      hostname: ${NAS_HOST:-nas.example.com}
+      port: ${SSH_PORT}
+archives:
+  laptop:
+    include: [/data]
+targets:
+  - archive: laptop
+    store: usb
+  - archive: laptop
+    store: nas
+`)
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.TargetMap["laptop:usb"].Store.Local; got != "/mnt/backup/usb" {
+		t.Errorf("local store = %q, want /mnt/backup/usb", got)
+	}
+	ssh := cfg.TargetMap["laptop:nas"].Store.SSH
+	if ssh.Hostname != "nas.example.com" || ssh.Port != 2222 {
+		t.Errorf("ssh store = %s:%d, want nas.example.com:2222", ssh.Hostname, ssh.Port)
+	}
+
+	path = writeConfig(t, "archives:\n  laptop:\n    include:\n      - ${UNDEFINED_BORGDRONE_DIR}\n")
+	_, err = ReadConfigFile(path)
+	if err == nil || err.Error() != "Invalid configuration ("+path+"):\n  "+path+":4:9: undefined variable 'UNDEFINED_BORGDRONE_DIR'" {
+		t.Errorf("expected an undefined variable problem, got %v", err)
+	}
+}
+
+func TestReadConfigFileInterpolationSkipsCommands(t *testing.T) {
+	t.Setenv("BACKUP_ROOT", "/mnt/backup")
+	path := writeConfig(t, `
+notify:
+  commands:
+    - echo ${BORGDRONE_TARGET}
+stores:
+  filesystem:
+    usb:
+      path: ${BACKUP_ROOT}/usb
+      passphrase:
+        backend: command
+        command: pass show ${BORGDRONE_STORE}
+archives:
+  laptop:
+    include: [/data]
+    before_create:
+      - echo ${BORGDRONE_TARGET}
+    finally:
+      - command: rm -f ${BORGDRONE_CONFIG_DIR}/dump
+        timeout: 1m
+targets:
+  - archive: laptop
+    store: usb
+`)
+	cfg, err := ReadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := cfg.TargetMap["laptop:usb"]
+	if got := target.Store.Local; got != "/mnt/backup/usb" {
+		t.Errorf("local store = %q, want /mnt/backup/usb", got)
+	}
+	commands := []struct{ got, want string }{
+		{target.Hooks.BeforeCreate[0].Command, "echo ${BORGDRONE_TARGET}"},
+		{target.Hooks.Finally[0].Command, "rm -f ${BORGDRONE_CONFIG_DIR}/dump"},
+		{target.Notify.Commands[0], "echo ${BORGDRONE_TARGET}"},
+		{target.Passphrase.Command, "pass show ${BORGDRONE_STORE}"},
+	}
+	for _, c := range commands {
+		if c.got != c.want {
+			t.Errorf("command = %q, want %q", c.got, c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"net/url"
+)
+
+// NotifyOptions configures the notifications sent when the backup pipeline of a target finishes.
+// Notifications are always sent on failure, and on success only if OnSuccess is set
+type NotifyOptions struct {
+	OnSuccess bool `yaml:"on_success"`
+	// Commands are run with `sh -c`, receiving the JSON payload on stdin and BORGDRONE_* environment variables
+	Commands []string
+	Webhook  *WebhookOptions
+	Email    *EmailOptions
+}
+
+// WebhookOptions configures a generic HTTP webhook, which receives the JSON payload in a POST request
+type WebhookOptions struct {
+	URL     string `schema:"required"`
+	Headers map[string]string
+}
+
+// EmailOptions configures notification emails sent through an SMTP server
+type EmailOptions struct {
+	Host     string `schema:"required"`
+	Port     int
+	Username string
+	Password string
+	From     string   `schema:"required"`
+	To       []string `schema:"required"`
+}
+
+// IsEmpty returns true if no notifications are configured
+func (n NotifyOptions) IsEmpty() bool {
+	return len(n.Commands) == 0 && n.Webhook == nil && n.Email == nil
+}
+
+// validate reports invalid notification options. Missing values are reported like the schema reports them
+func (n NotifyOptions) validate(p *configFiles) {
+	if n.Webhook != nil {
+		if n.Webhook.URL == "" {
+			p.addf([]any{"notify", "webhook", "url"}, "missing required field 'url'")
+		} else if u, err := url.Parse(n.Webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			p.addf([]any{"notify", "webhook", "url"}, "notify webhook url '%s' must be an http or https URL", n.Webhook.URL)
+		}
+	}
+	if n.Email != nil {
+		if n.Email.Host == "" {
+			p.addf([]any{"notify", "email", "host"}, "missing required field 'host'")
+		}
+		if n.Email.From == "" {
+			p.addf([]any{"notify", "email", "from"}, "missing required field 'from'")
+		}
+		if len(n.Email.To) == 0 {
+			p.addf([]any{"notify", "email", "to"}, "missing required field 'to'")
+		}
+	}
+}
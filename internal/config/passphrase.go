@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultPassphraseLength  = 32
+	defaultPassphraseCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	passphraseWordSeparator  = "-"
+	// minPassphraseBits is the least entropy a generated passphrase may have
+	minPassphraseBits = 80
+)
+
+//go:embed wordlist.txt
+var wordlistData string
+
+var wordlist = parseWordlist(wordlistData)
+
+// parseWordlist returns the words of the embedded wordlist, skipping its # comment header
+func parseWordlist(data string) []string {
+	words := []string{}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words
+}
+
+// PassphraseOptions selects the SecretProvider backend for a repository passphrase,
+// and controls how new passphrases are generated for backends which borgdrone can write to.
+// If Words is set, a diceware-style passphrase is generated from the embedded wordlist,
+// otherwise Length characters are chosen from Charset
+type PassphraseOptions struct {
+	Backend    string `json:",omitempty" yaml:",omitempty"`
+	Command    string `json:",omitempty" yaml:",omitempty"`
+	Env        string `json:",omitempty" yaml:",omitempty"`
+	Credential string `json:",omitempty" yaml:",omitempty"`
+	Length     int    `json:",omitempty" yaml:",omitempty"`
+	Charset    string `json:",omitempty" yaml:",omitempty"`
+	Words      int    `json:",omitempty" yaml:",omitempty"`
+}
+
+// merge returns a copy of these options with any values set in override taking precedence
+func (p PassphraseOptions) merge(override PassphraseOptions) PassphraseOptions {
+	if override.Backend != "" {
+		p.Backend = override.Backend
+	}
+	if override.Command != "" {
+		p.Command = override.Command
+	}
+	if override.Env != "" {
+		p.Env = override.Env
+	}
+	if override.Credential != "" {
+		p.Credential = override.Credential
+	}
+	if override.Length != 0 {
+		p.Length = override.Length
+	}
+	if override.Charset != "" {
+		p.Charset = override.Charset
+	}
+	if override.Words != 0 {
+		p.Words = override.Words
+	}
+	return p
+}
+
+// validate checks the passphrase generation options are consistent
+func (p PassphraseOptions) validate() error {
+	if p.Length < 0 || p.Words < 0 {
+		return errors.New("passphrase length and words must not be negative")
+	}
+	if p.Words > 0 && (p.Length > 0 || p.Charset != "") {
+		return errors.New("passphrase words cannot be combined with length or charset")
+	}
+	if p.Words == 0 && len(p.charset()) < 2 {
+		return errors.New("passphrase charset must contain at least 2 distinct characters")
+	}
+	if bits := p.strength(); bits < minPassphraseBits {
+		return fmt.Errorf("generated passphrases would have %.0f bits of entropy, at least %d are required. Increase passphrase length or words", bits, minPassphraseBits)
+	}
+	return nil
+}
+
+// charset returns the distinct characters which generated passphrases are chosen from
+func (p PassphraseOptions) charset() []rune {
+	chars := p.Charset
+	if chars == "" {
+		chars = defaultPassphraseCharset
+	}
+	charset := []rune{}
+	for _, r := range chars {
+		if !slices.Contains(charset, r) {
+			charset = append(charset, r)
+		}
+	}
+	return charset
+}
+
+// length returns the number of characters in generated passphrases
+func (p PassphraseOptions) length() int {
+	if p.Length == 0 {
+		return defaultPassphraseLength
+	}
+	return p.Length
+}
+
+// strength returns the entropy in bits of passphrases generated with these options
+func (p PassphraseOptions) strength() float64 {
+	if p.Words > 0 {
+		return float64(p.Words) * math.Log2(float64(len(wordlist)))
+	}
+	return float64(p.length()) * math.Log2(float64(len(p.charset())))
+}
+
+// randomIndex returns a uniformly distributed random integer in [0, n) from a cryptographically secure source
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// GeneratePassphrase returns a new random passphrase according to the provided options
+func GeneratePassphrase(opts PassphraseOptions) (string, error) {
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	if opts.Words > 0 {
+		words := make([]string, opts.Words)
+		for i := range words {
+			idx, err := randomIndex(len(wordlist))
+			if err != nil {
+				return "", err
+			}
+			words[i] = wordlist[idx]
+		}
+		return strings.Join(words, passphraseWordSeparator), nil
+	}
+
+	charset := opts.charset()
+	var b strings.Builder
+	for range opts.length() {
+		idx, err := randomIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(charset[idx])
+	}
+	return b.String(), nil
+}
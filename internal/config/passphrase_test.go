@@ -0,0 +1,76 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassphrase(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    PassphraseOptions
+		length  int
+		charset string
+		words   int
+		wantErr bool
+	}{
+		{name: "defaults", length: defaultPassphraseLength, charset: defaultPassphraseCharset},
+		{name: "length", opts: PassphraseOptions{Length: 20}, length: 20, charset: defaultPassphraseCharset},
+		{name: "charset", opts: PassphraseOptions{Length: 40, Charset: "0123456789"}, length: 40, charset: "0123456789"},
+		{name: "words", opts: PassphraseOptions{Words: 8}, words: 8},
+		{name: "words and length are exclusive", opts: PassphraseOptions{Words: 8, Length: 20}, wantErr: true},
+		{name: "words and charset are exclusive", opts: PassphraseOptions{Words: 8, Charset: "abc"}, wantErr: true},
+		{name: "too few words", opts: PassphraseOptions{Words: 1}, wantErr: true},
+		{name: "too short", opts: PassphraseOptions{Length: 1}, wantErr: true},
+		{name: "charset too small", opts: PassphraseOptions{Length: 100, Charset: "aaaa"}, wantErr: true},
+		{name: "repeated characters do not add strength", opts: PassphraseOptions{Length: 20, Charset: "0123456789012345678901234567890123456789"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GeneratePassphrase(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GeneratePassphrase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.words > 0 {
+				words := strings.Split(got, passphraseWordSeparator)
+				if len(words) != tt.words {
+					t.Errorf("passphrase %q has %d words, want %d", got, len(words), tt.words)
+				}
+				for _, w := range words {
+					if !strings.Contains(wordlistData, "\n"+w+"\n") {
+						t.Errorf("word %q is not in the wordlist", w)
+					}
+				}
+				return
+			}
+			if n := len([]rune(got)); n != tt.length {
+				t.Errorf("passphrase %q has length %d, want %d", got, n, tt.length)
+			}
+			for _, r := range got {
+				if !strings.ContainsRune(tt.charset, r) {
+					t.Errorf("passphrase %q contains %q which is not in the charset", got, r)
+				}
+			}
+		})
+	}
+}
+
+func TestWordlist(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, w := range wordlist {
+		if strings.HasPrefix(w, "#") || strings.ContainsAny(w, " -") {
+			t.Errorf("invalid word %q", w)
+		}
+		if seen[w] {
+			t.Errorf("duplicate word %q", w)
+		}
+		seen[w] = true
+	}
+	if len(wordlist) < 1024 {
+		t.Errorf("wordlist has %d words, want at least 1024", len(wordlist))
+	}
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the subset of JSON Schema generated from the configuration types
+type Schema struct {
+	Schema     string             `json:"$schema,omitempty"`
+	Ref        string             `json:"$ref,omitempty"`
+	Defs       map[string]*Schema `json:"$defs,omitempty"`
+	Properties *SchemaProperties  `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is either false or the *Schema of every value of a map
+	AdditionalProperties any       `json:"additionalProperties,omitempty"`
+	OneOf                []*Schema `json:"oneOf,omitempty"`
+	Type                 string    `json:"type,omitempty"`
+	Required             []string  `json:"required,omitempty"`
+}
+
+// SchemaProperties holds the properties of an object schema in the order the fields are declared
+type SchemaProperties struct {
+	Names   []string
+	Schemas map[string]*Schema
+}
+
+func (p *SchemaProperties) add(name string, s *Schema) {
+	if _, ok := p.Schemas[name]; !ok {
+		p.Names = append(p.Names, name)
+	}
+	p.Schemas[name] = s
+}
+
+func (p *SchemaProperties) MarshalJSON() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString("{")
+	for i, name := range p.Names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(p.Schemas[name])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// schemaProvider is implemented by types whose YAML form does not follow their Go fields,
+// which is the case for every type with an UnmarshalYAML method
+type schemaProvider interface {
+	yamlSchema() *Schema
+}
+
+var schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+
+// typeSchema returns the schema of the YAML accepted when decoding into t
+func typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).yamlSchema()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Slice:
+		return &Schema{Items: typeSchema(t.Elem()), Type: "array"}
+	case reflect.Map:
+		return &Schema{AdditionalProperties: typeSchema(t.Elem()), Type: "object"}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	panic(fmt.Sprintf("no schema for configuration type %s", t))
+}
+
+// yamlFieldName returns the key of a struct field following the naming rules of yaml.v3, and whether it is inlined
+func yamlFieldName(f reflect.StructField) (string, bool) {
+	name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name, slices.Contains(strings.Split(opts, ","), "inline")
+}
+
+// structSchema returns the object schema of a struct, ignoring any schemaProvider implementation.
+// Fields are named following yaml.v3, and fields tagged `schema:"required"` are required
+func structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Properties:           &SchemaProperties{Schemas: make(map[string]*Schema)},
+		AdditionalProperties: false,
+		Type:                 "object",
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, inline := yamlFieldName(f)
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if inline {
+			inline := structSchema(f.Type)
+			for _, name := range inline.Properties.Names {
+				s.Properties.add(name, inline.Properties.Schemas[name])
+			}
+			s.Required = append(s.Required, inline.Required...)
+			continue
+		}
+		s.Properties.add(name, typeSchema(f.Type))
+		if f.Tag.Get("schema") == "required" {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// JSONSchema returns the JSON Schema of the configuration file, generated from ConfigYaml
+func JSONSchema() ([]byte, error) {
+	s := &Schema{
+		Schema: "https://json-schema.org/draft/2020-12/schema",
+		Ref:    "#/$defs/ConfigYaml",
+		Defs:   map[string]*Schema{"ConfigYaml": typeSchema(reflect.TypeOf(ConfigYaml{}))},
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// yamlType returns the JSON Schema type of a YAML node, or "" for null
+func yamlType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return ""
+		case "!!bool":
+			return "boolean"
+		case "!!int":
+			return "integer"
+		case "!!float":
+			return "number"
+		default:
+			return "string"
+		}
+	}
+	return ""
+}
+
+// typeMatches reports whether a node can be decoded as the schema type.
+// Null is accepted everywhere, as it decodes to the zero value, and any scalar can be decoded into a string
+func typeMatches(node *yaml.Node, schemaType string) bool {
+	nodeType := yamlType(node)
+	if nodeType == "" || nodeType == schemaType {
+		return true
+	}
+	return schemaType == "string" && node.Kind == yaml.ScalarNode
+}
+
+// validate checks a YAML node against the schema, recording a problem for each violation
+func (s *Schema) validate(node *yaml.Node, p *problems) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) > 0 {
+			s.validate(node.Content[0], p)
+		}
+		return
+	}
+	at := func(n *yaml.Node, format string, a ...any) {
+		p.list = append(p.list, Problem{Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, a...)})
+	}
+	// A value of the wrong type cannot be decoded, unlike unknown or missing fields
+	mismatch := func(n *yaml.Node, format string, a ...any) {
+		p.fatal = true
+		at(n, format, a...)
+	}
+
+	if len(s.OneOf) > 0 {
+		types := []string{}
+		for _, option := range s.OneOf {
+			if yamlType(node) != "" && typeMatches(node, option.Type) {
+				option.validate(node, p)
+				return
+			}
+			types = append(types, option.Type)
+		}
+		if yamlType(node) != "" {
+			mismatch(node, "expected %s, got %s", strings.Join(types, " or "), yamlType(node))
+		}
+		return
+	}
+
+	if !typeMatches(node, s.Type) {
+		mismatch(node, "expected %s, got %s", s.Type, yamlType(node))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			s.Items.validate(item, p)
+		}
+	case yaml.MappingNode:
+		present := []string{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			present = append(present, key.Value)
+			if s.Properties != nil {
+				if prop, ok := s.Properties.Schemas[key.Value]; ok {
+					prop.validate(value, p)
+					continue
+				}
+			}
+			if additional, ok := s.AdditionalProperties.(*Schema); ok {
+				additional.validate(value, p)
+			} else {
+				at(key, "unknown field '%s'", key.Value)
+			}
+		}
+		for _, name := range s.Required {
+			if !slices.Contains(present, name) {
+				at(node, "missing required field '%s'", name)
+			}
+		}
+	}
+}
+
+// validateSchema checks a parsed configuration document against the generated schema
+func validateSchema(root *yaml.Node, p *problems) {
+	typeSchema(reflect.TypeOf(ConfigYaml{})).validate(root, p)
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestJSONSchemaUpToDate(t *testing.T) {
+	want, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("schema.json does not match ConfigYaml, regenerate it with `borgdrone config schema > schema.json`")
+	}
+}
+
+func TestExampleConfigMatchesSchema(t *testing.T) {
+	for _, path := range []string{"../../example.yml", "default.yml"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, p := decodeConfig(data); len(p.list) > 0 {
+			t.Errorf("%s: %v", path, p.sorted())
+		}
+	}
+}
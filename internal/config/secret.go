@@ -0,0 +1,228 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// SecretProvider supplies the passphrase for a borg repository
+type SecretProvider interface {
+	// Environment returns the variables which allow borg to obtain the passphrase
+	Environment() []string
+	// Passphrase returns the current passphrase
+	Passphrase() (string, error)
+}
+
+// SecretStore is implemented by providers which borgdrone is able to write passphrases to.
+// Backends which only read a passphrase managed elsewhere do not need to implement it
+type SecretStore interface {
+	SecretProvider
+	// Create stores the passphrase for a new repository. An existing passphrase is never overwritten
+	Create(passphrase string) error
+	// Stage durably stores a replacement passphrase next to the current one, returning where it was stored.
+	// It is called before the repository key is changed, so the new passphrase cannot be lost
+	Stage(passphrase string) (string, error)
+	// Commit atomically replaces the current passphrase with the staged one
+	Commit() error
+	// Discard removes a staged passphrase which was not committed
+	Discard() error
+}
+
+// shellQuote quotes s as a single shell word. borg splits BORG_PASSCOMMAND into words like a shell would
+// (Python's shlex) and runs it without a shell, so paths and commands must be quoted to survive the split
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// SecretBackend constructs the SecretProvider for a target, using the target's PassphraseOptions
+type SecretBackend func(t Target) (SecretProvider, error)
+
+const defaultSecretBackend = "file"
+
+var secretBackends = map[string]SecretBackend{
+	"file":    newFileSecretProvider,
+	"command": newCommandSecretProvider,
+	"env":     newEnvSecretProvider,
+	"systemd": newSystemdSecretProvider,
+}
+
+// RegisterSecretBackend makes a new backend available to the `passphrase.backend` configuration option
+func RegisterSecretBackend(name string, backend SecretBackend) {
+	secretBackends[name] = backend
+}
+
+// GetSecretProvider returns the SecretProvider configured for this target
+func (t Target) GetSecretProvider() (SecretProvider, error) {
+	name := t.Passphrase.Backend
+	if name == "" {
+		name = defaultSecretBackend
+	}
+	backend, ok := secretBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown passphrase backend '%s'", name)
+	}
+	return backend(t)
+}
+
+// FileSecretProvider reads the passphrase from a plaintext file in the target's config directory
+type FileSecretProvider struct {
+	Path        string
+	PendingPath string
+}
+
+func newFileSecretProvider(t Target) (SecretProvider, error) {
+	return &FileSecretProvider{Path: t.GetPasswordFile(), PendingPath: t.GetNewPasswordFile()}, nil
+}
+
+func (p *FileSecretProvider) Environment() []string {
+	return []string{"BORG_PASSCOMMAND=cat " + shellQuote(p.Path)}
+}
+
+func (p *FileSecretProvider) Passphrase() (string, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (p *FileSecretProvider) Create(passphrase string) error {
+	if err := os.MkdirAll(path.Dir(p.Path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(p.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(passphrase)
+	return err
+}
+
+// Stage writes the new passphrase to PendingPath
+func (p *FileSecretProvider) Stage(passphrase string) (string, error) {
+	if err := os.MkdirAll(path.Dir(p.Path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(p.PendingPath, []byte(passphrase), 0600); err != nil {
+		return "", err
+	}
+	// WriteFile does not change the mode of an existing file
+	if err := os.Chmod(p.PendingPath, 0600); err != nil {
+		return "", err
+	}
+	return p.PendingPath, nil
+}
+
+// Commit renames PendingPath over Path
+func (p *FileSecretProvider) Commit() error {
+	return os.Rename(p.PendingPath, p.Path)
+}
+
+func (p *FileSecretProvider) Discard() error {
+	err := os.Remove(p.PendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CommandSecretProvider runs an external command such as `pass` or `secret-tool` which prints the passphrase.
+// The command is run with `sh -c` both by borgdrone and by borg, so pipes and variables work the same in each
+type CommandSecretProvider struct {
+	Command string
+}
+
+func newCommandSecretProvider(t Target) (SecretProvider, error) {
+	if t.Passphrase.Command == "" {
+		return nil, errors.New("passphrase backend 'command' requires a command")
+	}
+	return &CommandSecretProvider{Command: t.Passphrase.Command}, nil
+}
+
+func (p *CommandSecretProvider) Environment() []string {
+	return []string{"BORG_PASSCOMMAND=sh -c " + shellQuote(p.Command)}
+}
+
+func (p *CommandSecretProvider) Passphrase() (string, error) {
+	out, err := exec.Command("sh", "-c", p.Command).Output()
+	if err != nil {
+		return "", fmt.Errorf("passphrase command failed: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// EnvSecretProvider reads the passphrase from an environment variable of the borgdrone process
+type EnvSecretProvider struct {
+	Variable string
+}
+
+func newEnvSecretProvider(t Target) (SecretProvider, error) {
+	if t.Passphrase.Env == "" {
+		return nil, errors.New("passphrase backend 'env' requires an env variable name")
+	}
+	return &EnvSecretProvider{Variable: t.Passphrase.Env}, nil
+}
+
+func (p *EnvSecretProvider) Environment() []string {
+	passphrase, err := p.Passphrase()
+	if err != nil {
+		return []string{}
+	}
+	return []string{"BORG_PASSPHRASE=" + passphrase}
+}
+
+func (p *EnvSecretProvider) Passphrase() (string, error) {
+	passphrase, ok := os.LookupEnv(p.Variable)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", p.Variable)
+	}
+	return passphrase, nil
+}
+
+// SystemdSecretProvider reads the passphrase from a systemd credential (LoadCredential= / SetCredentialEncrypted=)
+type SystemdSecretProvider struct {
+	Credential string
+}
+
+func newSystemdSecretProvider(t Target) (SecretProvider, error) {
+	if t.Passphrase.Credential == "" {
+		return nil, errors.New("passphrase backend 'systemd' requires a credential name")
+	}
+	return &SystemdSecretProvider{Credential: t.Passphrase.Credential}, nil
+}
+
+// Path returns the location of the credential within $CREDENTIALS_DIRECTORY
+func (p *SystemdSecretProvider) Path() (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", errors.New("CREDENTIALS_DIRECTORY is not set. Is borgdrone running as a systemd service?")
+	}
+	return path.Join(dir, p.Credential), nil
+}
+
+func (p *SystemdSecretProvider) Environment() []string {
+	credentialPath, err := p.Path()
+	if err != nil {
+		return []string{}
+	}
+	return []string{"BORG_PASSCOMMAND=cat " + shellQuote(credentialPath)}
+}
+
+func (p *SystemdSecretProvider) Passphrase() (string, error) {
+	credentialPath, err := p.Path()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(credentialPath)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
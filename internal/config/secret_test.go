@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runPasscommand runs a BORG_PASSCOMMAND from env the way borg does, split into words and without a shell
+func runPasscommand(t *testing.T, env []string) string {
+	t.Helper()
+	for _, v := range env {
+		if command, ok := strings.CutPrefix(v, "BORG_PASSCOMMAND="); ok {
+			// sh parses the quoting of the words exactly like borg's shlex.split, then runs them directly
+			out, err := exec.Command("sh", "-c", "exec "+command).Output()
+			if err != nil {
+				t.Fatalf("BORG_PASSCOMMAND %q failed: %v", command, err)
+			}
+			return strings.TrimRight(string(out), "\n")
+		}
+	}
+	t.Fatalf("environment %q has no BORG_PASSCOMMAND", env)
+	return ""
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "laptop usb")
+	p := &FileSecretProvider{Path: filepath.Join(dir, "password"), PendingPath: filepath.Join(dir, "password.new")}
+
+	if err := p.Create("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Create("ignored"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.Passphrase(); got != "first" {
+		t.Errorf("Create() overwrote the passphrase, got %q", got)
+	}
+	if got := runPasscommand(t, p.Environment()); got != "first" {
+		t.Errorf("BORG_PASSCOMMAND printed %q, want first", got)
+	}
+
+	staged, err := p.Stage("discarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged != p.PendingPath {
+		t.Errorf("Stage() = %q, want %q", staged, p.PendingPath)
+	}
+	if err := p.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p.PendingPath); !os.IsNotExist(err) {
+		t.Errorf("Discard() left the staged passphrase: %v", err)
+	}
+	if err := p.Discard(); err != nil {
+		t.Errorf("Discard() without a staged passphrase: %v", err)
+	}
+
+	if _, err := p.Stage("second"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.Passphrase(); got != "first" {
+		t.Errorf("Stage() replaced the passphrase before Commit(), got %q", got)
+	}
+	if err := p.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.Passphrase(); got != "second" {
+		t.Errorf("passphrase after Commit() = %q, want second", got)
+	}
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("passphrase file mode = %o, want 600", info.Mode().Perm())
+	}
+}
+
+func TestCommandSecretProvider(t *testing.T) {
+	p := &CommandSecretProvider{Command: `printf 'first line\nsecond\n' | head -n 1`}
+
+	got, err := p.Passphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "first line" {
+		t.Errorf("Passphrase() = %q, want %q", got, "first line")
+	}
+	if got := runPasscommand(t, p.Environment()); got != "first line" {
+		t.Errorf("BORG_PASSCOMMAND printed %q, want %q", got, "first line")
+	}
+
+	p = &CommandSecretProvider{Command: "exit 3"}
+	if _, err := p.Passphrase(); err == nil {
+		t.Error("expected a failing command to return an error")
+	}
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	p := &EnvSecretProvider{Variable: "BORGDRONE_TEST_PASSPHRASE"}
+	t.Setenv("BORGDRONE_TEST_PASSPHRASE", "")
+	os.Unsetenv("BORGDRONE_TEST_PASSPHRASE")
+
+	if _, err := p.Passphrase(); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+	if env := p.Environment(); len(env) != 0 {
+		t.Errorf("Environment() = %q for an unset variable, want none", env)
+	}
+
+	t.Setenv("BORGDRONE_TEST_PASSPHRASE", "s3cret")
+	if got, _ := p.Passphrase(); got != "s3cret" {
+		t.Errorf("Passphrase() = %q, want s3cret", got)
+	}
+	if env := p.Environment(); !slices.Equal(env, []string{"BORG_PASSPHRASE=s3cret"}) {
+		t.Errorf("Environment() = %q", env)
+	}
+}
+
+func TestSystemdSecretProvider(t *testing.T) {
+	p := &SystemdSecretProvider{Credential: "borgdrone-laptop"}
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+
+	if _, err := p.Passphrase(); err == nil {
+		t.Error("expected an error without CREDENTIALS_DIRECTORY")
+	}
+	if env := p.Environment(); len(env) != 0 {
+		t.Errorf("Environment() = %q without CREDENTIALS_DIRECTORY, want none", env)
+	}
+
+	dir := filepath.Join(t.TempDir(), "credentials dir")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "borgdrone-laptop"), []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	if got, _ := p.Passphrase(); got != "s3cret" {
+		t.Errorf("Passphrase() = %q, want s3cret", got)
+	}
+	if got := runPasscommand(t, p.Environment()); got != "s3cret" {
+		t.Errorf("BORG_PASSCOMMAND printed %q, want s3cret", got)
+	}
+}
+
+type staticSecretProvider string
+
+func (p staticSecretProvider) Environment() []string {
+	return []string{"BORG_PASSPHRASE=" + string(p)}
+}
+
+func (p staticSecretProvider) Passphrase() (string, error) {
+	return string(p), nil
+}
+
+func TestRegisterSecretBackend(t *testing.T) {
+	RegisterSecretBackend("static", func(t Target) (SecretProvider, error) {
+		return staticSecretProvider(t.Passphrase.Credential), nil
+	})
+	t.Cleanup(func() { delete(secretBackends, "static") })
+
+	target := Target{Passphrase: PassphraseOptions{Backend: "static", Credential: "s3cret"}}
+	provider, err := target.GetSecretProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := provider.Passphrase(); got != "s3cret" {
+		t.Errorf("Passphrase() = %q, want s3cret", got)
+	}
+
+	target.Passphrase.Backend = "missing"
+	if _, err := target.GetSecretProvider(); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
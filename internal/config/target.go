@@ -2,12 +2,11 @@ package config
 
 import (
 	_ "embed"
-	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 type StoreType string
@@ -40,6 +39,12 @@ type PruneOptions struct {
 	KeepYearly  int `json:",omitempty" yaml:",omitempty"`
 }
 
+// IsEmpty returns true if no keep values have been configured.
+// Running borg prune without any keep rules would delete every archive in the repository
+func (p PruneOptions) IsEmpty() bool {
+	return p.KeepDaily == 0 && p.KeepWeekly == 0 && p.KeepMonthly == 0 && p.KeepYearly == 0
+}
+
 // Target is a struct used to hold information about a single borg target
 // Store and Archive are read from the separate YAML section and copied here
 // This avoids the need to reference into the YAML parser struct
@@ -55,9 +60,17 @@ type Target struct {
 	Encryption       string
 	Compression      string
 	Compact          bool
-	OneFileSystem     bool
+	OneFileSystem    bool
 	Prune            PruneOptions
 	RcloneUploadPath string `json:",omitempty" yaml:",omitempty"`
+	Passphrase       PassphraseOptions
+	Schedule         string        `json:",omitempty" yaml:",omitempty"`
+	MaxAge           time.Duration `json:",omitempty" yaml:",omitempty"`
+	// ArchiveNameTemplate names the archives created for this target, see GetArchiveName
+	ArchiveNameTemplate string `json:",omitempty" yaml:",omitempty"`
+	// Notify is copied from the global notify section. It is not printed, as it may contain credentials
+	Notify NotifyOptions `json:"-" yaml:"-"`
+	Hooks  Hooks         `json:"-" yaml:"-"`
 }
 
 // GetName Returns a human-readable label for this target
@@ -78,6 +91,11 @@ func (t Target) GetPasswordFile() string {
 	return path.Join(t.GetConfigPath(), "passwd")
 }
 
+// GetNewPasswordFile returns the path where a replacement password is staged while the passphrase is rotated
+func (t Target) GetNewPasswordFile() string {
+	return path.Join(t.GetConfigPath(), "passwd.new")
+}
+
 // GetKeyfile returns the path to the (binary) keyfile
 func (t Target) GetKeyfile() string {
 	return path.Join(t.GetConfigPath(), "keyfile.bin")
@@ -88,6 +106,26 @@ func (t Target) GetPaperKeyfile() string {
 	return path.Join(t.GetConfigPath(), "keyfile.txt")
 }
 
+// GetMountsFile returns the path to the file tracking active `borg mount` mountpoints
+func (t Target) GetMountsFile() string {
+	return path.Join(t.GetConfigPath(), "mounts.json")
+}
+
+// GetLockFile returns the path to the lock file which prevents overlapping runs against this target
+func (t Target) GetLockFile() string {
+	return path.Join(t.GetConfigPath(), "lock")
+}
+
+// GetLastRunFile returns the path to the file recording when the daemon last ran this target
+func (t Target) GetLastRunFile() string {
+	return path.Join(t.GetConfigPath(), "last_run")
+}
+
+// GetStatusFile returns the path to the file recording the outcome of recent commands for this target
+func (t Target) GetStatusFile() string {
+	return path.Join(t.GetConfigPath(), "status.json")
+}
+
 // IsInitialised will return true if this target has already been initialised (keys/passwords are generated)
 func (t Target) IsInitialised() bool {
 	if _, err := os.Stat(path.Join(t.GetConfigPath(), ".initialised")); err == nil {
@@ -129,12 +167,70 @@ func (t Target) GetBorgRepositoryPath() string {
 	}
 }
 
+// GetRcloneUploadPath returns the rclone remote path which the local repository is synced to
+// The archive name is appended to the configured path, mirroring the layout of the local store
+func (t Target) GetRcloneUploadPath() string {
+	if t.RcloneUploadPath == "" {
+		return ""
+	}
+	if strings.HasSuffix(t.RcloneUploadPath, ":") || strings.HasSuffix(t.RcloneUploadPath, "/") {
+		return t.RcloneUploadPath + t.ArchiveName
+	}
+	return t.RcloneUploadPath + "/" + t.ArchiveName
+}
+
+// DefaultArchiveNameTemplate is used for targets and archives which do not set archive_name
+const DefaultArchiveNameTemplate = "{hostname}-{archive}-{now:%Y-%m-%dT%H:%M:%S}"
+
+// archiveNameTemplate returns the configured archive name template, or the default one
+func (t Target) archiveNameTemplate() string {
+	if t.ArchiveNameTemplate == "" {
+		return DefaultArchiveNameTemplate
+	}
+	return t.ArchiveNameTemplate
+}
+
+// GetArchiveName returns the name of a new archive for borg create.
+// {archive}, {store} and {hostname} are expanded here, so archive names can be matched by GetArchiveGlob,
+// while other placeholders such as {now} are left for borg to expand
+func (t Target) GetArchiveName() string {
+	hostname, _ := os.Hostname()
+	// borg uses the short hostname for {hostname}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return strings.NewReplacer(
+		"{archive}", t.ArchiveName,
+		"{store}", t.StoreName,
+		"{hostname}", hostname,
+	).Replace(t.archiveNameTemplate())
+}
+
+// GetArchiveGlob returns a --glob-archives pattern matching the archives created for this target, from the literal
+// text of the archive name up to its first borg placeholder. An empty string is returned if there is no such prefix
+func (t Target) GetArchiveGlob() string {
+	prefix, _, _ := strings.Cut(t.GetArchiveName(), "{")
+	if prefix == "" {
+		return ""
+	}
+	// Escape glob metacharacters so they match literally
+	var b strings.Builder
+	for _, r := range prefix {
+		if strings.ContainsRune("*?[", r) {
+			b.WriteString("[" + string(r) + "]")
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String() + "*"
+}
+
 // GetEnvironment
 func (t Target) GetEnvironment() []string {
 	e := []string{
 		"BORG_RELOCATED_REPO_ACCESS_IS_OK=yes",
 	}
-	e = append(e, fmt.Sprintf("BORG_PASSCOMMAND=cat %s", t.GetPasswordFile()))
+	if provider, err := t.GetSecretProvider(); err == nil {
+		e = append(e, provider.Environment()...)
+	}
 	e = append(e, fmt.Sprintf("BORG_REPO=%s", t.GetBorgRepositoryPath()))
 
 	if t.StoreType == SSHStore {
@@ -147,29 +243,14 @@ func (t Target) GetEnvironment() []string {
 	return e
 }
 
-// CreatePasswordFile
-func (t Target) CreatePasswordFile() {
-	os.MkdirAll(t.GetConfigPath(), 0700)
-	file, err := os.OpenFile(t.GetPasswordFile(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
-	if errors.Is(err, os.ErrExist) {
-		return
-	} else if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	_, err = file.WriteString("SECRETPASSWORD")
-	if err != nil {
-		log.Fatal(err)
+// MarkInitialised records that the repository of this target has been initialised, see IsInitialised
+func (t Target) MarkInitialised() error {
+	if err := os.MkdirAll(t.GetConfigPath(), 0700); err != nil {
+		return fmt.Errorf("unable to mark target initialised: %w", err)
 	}
-
-	fmt.Println("Created " + t.GetPasswordFile())
-}
-
-// MarkInitialised
-func (t Target) MarkInitialised() {
-	_, err := os.Create(path.Join(t.GetConfigPath(), ".initialised"))
+	file, err := os.Create(path.Join(t.GetConfigPath(), ".initialised"))
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("unable to mark target initialised: %w", err)
 	}
+	return file.Close()
 }
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single error found in a configuration file.
+// Line and Column are 1-based, or 0 if the position is unknown
+type Problem struct {
+	// File is the configuration file containing the problem, which may be one included by the main file
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// ConfigError holds every problem found while reading a configuration file
+type ConfigError struct {
+	Path     string
+	Problems []Problem
+}
+
+func (e *ConfigError) Error() string {
+	lines := []string{fmt.Sprintf("Invalid configuration (%s):", e.Path)}
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.File+":"+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// problems collects the Problems of a configuration file, locating them in the YAML document
+type problems struct {
+	root *yaml.Node
+	list []Problem
+	// fatal is set if the document could not be decoded, or holds values of the wrong type.
+	// Its values cannot be trusted, so no further checks are made
+	fatal bool
+}
+
+// lookup follows a path of mapping keys (string) and sequence indexes (int) from the document root.
+// The node positioned at the last element is returned, which is the key node for mapping keys.
+// If the path does not exist, the deepest node found is returned so problems with missing values point at their parent
+func (p *problems) lookup(path ...any) *yaml.Node {
+	node := p.root
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for n, elem := range path {
+		var next *yaml.Node
+		switch key := elem.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+						if n == len(path)-1 {
+							next = node.Content[i]
+						}
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node
+}
+
+// addf records a problem at the node found by following path
+func (p *problems) addf(path []any, format string, a ...any) {
+	problem := Problem{Message: fmt.Sprintf(format, a...)}
+	if node := p.lookup(path...); node != nil {
+		problem.Line, problem.Column = node.Line, node.Column
+	}
+	p.list = append(p.list, problem)
+}
+
+// findColumn returns the column of a scalar with the given value on a line, or 0 if none is found
+func findColumn(node *yaml.Node, line int, value string) int {
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.ScalarNode && node.Line == line && node.Value == value {
+		return node.Column
+	}
+	for _, child := range node.Content {
+		if col := findColumn(child, line, value); col != 0 {
+			return col
+		}
+	}
+	return 0
+}
+
+var (
+	yamlLineRegex         = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlUnknownFieldRegex = regexp.MustCompile(`^field (\S+) not found in type .*$`)
+	yamlValueRegex        = regexp.MustCompile("`([^`]*)`")
+)
+
+// addYAMLError converts a YAML syntax or type error into problems
+func (p *problems) addYAMLError(err error) {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+	for _, msg := range messages {
+		problem := Problem{Message: msg}
+		if m := yamlLineRegex.FindStringSubmatch(msg); m != nil {
+			problem.Line, _ = strconv.Atoi(m[1])
+			problem.Column = 1
+			problem.Message = m[2]
+		}
+		value := ""
+		if m := yamlUnknownFieldRegex.FindStringSubmatch(problem.Message); m != nil {
+			problem.Message = fmt.Sprintf("unknown field '%s'", m[1])
+			value = m[1]
+		} else if m := yamlValueRegex.FindStringSubmatch(problem.Message); m != nil {
+			value = m[1]
+		}
+		if col := findColumn(p.root, problem.Line, value); value != "" && col != 0 {
+			problem.Column = col
+		}
+		p.list = append(p.list, problem)
+	}
+}
+
+// decodeConfig expands variable references in a configuration file, validates it against the schema and then decodes it.
+// Unknown and missing fields are only reported, so the rest of the configuration is still checked.
+// If a value does not have the type required by the schema the document is not decoded, as its values cannot be trusted
+// by later checks. Every type error is still reported, but the reference, duplicate and schedule checks of the whole
+// configuration are skipped until they are fixed
+func decodeConfig(data []byte) (ConfigYaml, *problems) {
+	var cfg ConfigYaml
+	p := &problems{}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		p.addYAMLError(err)
+		p.fatal = true
+		return cfg, p
+	}
+	p.root = &root
+	interpolateNode(&root, p)
+	if len(p.list) > 0 {
+		p.fatal = true
+		return cfg, p
+	}
+	validateSchema(&root, p)
+	if p.fatal {
+		return cfg, p
+	}
+
+	// Unknown fields are ignored here as the schema reported them, and the interpolated values are only held by the node
+	if root.Kind != 0 {
+		if err := root.Decode(&cfg); err != nil {
+			p.addYAMLError(err)
+			p.fatal = true
+		}
+	}
+	return cfg, p
+}
+
+// sorted returns the problems ordered by their position in the file.
+// A problem found both by the schema and by a later check, such as a missing required field, is only returned once
+func (p *problems) sorted() []Problem {
+	list := []Problem{}
+	for _, problem := range p.list {
+		if !slices.Contains(list, problem) {
+			list = append(list, problem)
+		}
+	}
+	slices.SortStableFunc(list, func(a, b Problem) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return list
+}
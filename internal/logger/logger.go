@@ -94,3 +94,12 @@ func (w Writer) Write(p []byte) (n int, err error) {
 	fmt.Print(colourise(msg, w.level))
 	return len(p), nil
 }
+
+// PrefixLine prepends "[prefix] " to a line of command output when prefix is set,
+// so the output of targets running in parallel can be told apart
+func PrefixLine(prefix string, line string) string {
+	if prefix == "" {
+		return line
+	}
+	return fmt.Sprintf("[%s] %s", prefix, line)
+}
@@ -0,0 +1,268 @@
+// Package notify sends notifications about the outcome of the backup pipeline of a target
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+	"codeberg.org/jstover/borgdrone/internal/logger"
+)
+
+// Payload is the JSON document sent to webhooks and written to the stdin of notification commands
+type Payload struct {
+	// Target is the ARCHIVE:STORE name of the target
+	Target string `json:"target"`
+	// Success is true if every step of the pipeline succeeded
+	Success bool `json:"success"`
+	// Step is the name of the step which failed, or the last step which ran on success
+	Step string `json:"step"`
+	// ExitCode is the borg exit code of the failed step, 1 for other errors, or 0 on success
+	ExitCode int `json:"exit_code"`
+	// Error is the error message of the failed step
+	Error string `json:"error,omitempty"`
+	// Stats are the statistics of the archive created by this run, if the create step succeeded
+	Stats *borg.ArchiveStats `json:"stats,omitempty"`
+	// StderrTail holds the last lines borg wrote to stderr in the failed step
+	StderrTail []string  `json:"stderr_tail,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+// StderrTailLines is the maximum number of stderr lines included in a Payload
+const StderrTailLines = 20
+
+// StderrTail returns the last StderrTailLines lines of borg stderr from err, if it is a borg ExitError
+func StderrTail(err error) []string {
+	var exitErr *borg.ExitError
+	if !errors.As(err, &exitErr) {
+		return nil
+	}
+	return exitErr.Stderr[max(0, len(exitErr.Stderr)-StderrTailLines):]
+}
+
+// Notifier delivers a Payload
+type Notifier interface {
+	Notify(ctx context.Context, p Payload) error
+}
+
+// Notifiers returns a Notifier for each notification configured in opts
+func Notifiers(opts config.NotifyOptions) []Notifier {
+	notifiers := []Notifier{}
+	for _, command := range opts.Commands {
+		notifiers = append(notifiers, &CommandNotifier{Command: command})
+	}
+	if opts.Webhook != nil {
+		notifiers = append(notifiers, &WebhookNotifier{URL: opts.Webhook.URL, Headers: opts.Webhook.Headers})
+	}
+	if opts.Email != nil {
+		notifiers = append(notifiers, &EmailNotifier{Options: *opts.Email})
+	}
+	return notifiers
+}
+
+// Send delivers the payload to every configured notifier.
+// Successful runs are only notified if OnSuccess is set
+func Send(ctx context.Context, opts config.NotifyOptions, p Payload) error {
+	if p.Success && !opts.OnSuccess {
+		return nil
+	}
+	errs := []error{}
+	for _, n := range Notifiers(opts) {
+		errs = append(errs, n.Notify(ctx, p))
+	}
+	return errors.Join(errs...)
+}
+
+func (p Payload) status() string {
+	if p.Success {
+		return "succeeded"
+	}
+	return "failed"
+}
+
+// CommandNotifier runs a shell command with the payload on stdin
+type CommandNotifier struct {
+	Command string
+}
+
+func (n *CommandNotifier) Notify(ctx context.Context, p Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	command := exec.CommandContext(ctx, "sh", "-c", n.Command)
+	command.Env = append(os.Environ(),
+		"BORGDRONE_TARGET="+p.Target,
+		"BORGDRONE_STATUS="+p.status(),
+		"BORGDRONE_STEP="+p.Step,
+		"BORGDRONE_EXIT_CODE="+strconv.Itoa(p.ExitCode),
+		"BORGDRONE_ERROR="+p.Error,
+	)
+	command.Stdin = bytes.NewReader(data)
+	output, err := command.CombinedOutput()
+	if len(output) > 0 {
+		logger.Debug("%s", strings.TrimSpace(string(output)))
+	}
+	if err != nil {
+		return fmt.Errorf("notify command '%s' failed: %w", n.Command, err)
+	}
+	return nil
+}
+
+// WebhookNotifier POSTs the payload as JSON to a URL.
+// Client defaults to an http.Client with a 30 second timeout
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, p Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "borgdrone")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notify webhook %s returned %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends a plain text email through an SMTP server.
+// PLAIN authentication is used when a username is configured, which net/smtp only allows over TLS or to localhost
+type EmailNotifier struct {
+	Options config.EmailOptions
+}
+
+// message formats the email headers and body for a payload
+func (n *EmailNotifier) message(p Payload) []byte {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "From: %s\r\n", n.Options.From)
+	fmt.Fprintf(b, "To: %s\r\n", strings.Join(n.Options.To, ", "))
+	fmt.Fprintf(b, "Subject: borgdrone: %s %s\r\n", p.Target, p.status())
+	fmt.Fprintf(b, "Date: %s\r\n", p.End.Format(time.RFC1123Z))
+	fmt.Fprintf(b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	line := func(label string, value any) {
+		fmt.Fprintf(b, "%-14s%v\r\n", label+":", value)
+	}
+	line("Target", p.Target)
+	line("Status", p.status())
+	line("Step", p.Step)
+	line("Exit code", p.ExitCode)
+	line("Started", p.Start.Format(time.DateTime))
+	line("Finished", p.End.Format(time.DateTime))
+	if p.Error != "" {
+		line("Error", p.Error)
+	}
+	if p.Stats != nil {
+		line("Files", p.Stats.NFiles)
+		line("Original", fmt.Sprintf("%d bytes", p.Stats.OriginalSize))
+		line("Deduplicated", fmt.Sprintf("%d bytes", p.Stats.DeduplicatedSize))
+	}
+	if len(p.StderrTail) > 0 {
+		fmt.Fprintf(b, "\r\nborg stderr:\r\n%s\r\n", strings.Join(p.StderrTail, "\r\n"))
+	}
+	return []byte(b.String())
+}
+
+// emailTimeout bounds the SMTP conversation when ctx has no earlier deadline
+const emailTimeout = 30 * time.Second
+
+func (n *EmailNotifier) Notify(ctx context.Context, p Payload) error {
+	if err := n.send(ctx, n.message(p)); err != nil {
+		return fmt.Errorf("notify email failed: %w", err)
+	}
+	return nil
+}
+
+// send delivers a message like smtp.SendMail, but bounded by ctx so a stalled server cannot block the pipeline
+func (n *EmailNotifier) send(ctx context.Context, msg []byte) error {
+	port := n.Options.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(n.Options.Host, strconv.Itoa(port))
+
+	ctx, cancel := context.WithTimeout(ctx, emailTimeout)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Unblock any pending read or write if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, n.Options.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.Options.Host}); err != nil {
+			return err
+		}
+	}
+	if n.Options.Username != "" {
+		auth := smtp.PlainAuth("", n.Options.Username, n.Options.Password, n.Options.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.Options.From); err != nil {
+		return err
+	}
+	for _, to := range n.Options.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/jstover/borgdrone/internal/borg"
+	"codeberg.org/jstover/borgdrone/internal/config"
+)
+
+func failurePayload() Payload {
+	return Payload{
+		Target:     "laptop:usb",
+		Step:       "prune",
+		ExitCode:   2,
+		Error:      "borg prune failed (exit code 2): repository locked",
+		Stats:      &borg.ArchiveStats{NFiles: 10, OriginalSize: 4096},
+		StderrTail: []string{"repository locked"},
+		Start:      time.Date(2024, time.March, 15, 2, 0, 0, 0, time.UTC),
+		End:        time.Date(2024, time.March, 15, 2, 5, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got Payload
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	want := failurePayload()
+	n := &WebhookNotifier{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}, Client: server.Client()}
+	if err := n.Notify(context.Background(), want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("payload = %+v, want %+v", got, want)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected headers %v", header)
+	}
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{URL: server.URL, Client: server.Client()}
+	err := n.Notify(context.Background(), failurePayload())
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected an error for status 500, got %v", err)
+	}
+}
+
+func TestSendOnSuccess(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	opts := config.NotifyOptions{Webhook: &config.WebhookOptions{URL: server.URL}}
+	success := Payload{Target: "laptop:usb", Success: true, Step: "upload"}
+	if err := Send(context.Background(), opts, success); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Errorf("success was notified without on_success")
+	}
+	opts.OnSuccess = true
+	if err := Send(context.Background(), opts, success); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("success was not notified with on_success")
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	n := &CommandNotifier{Command: `echo "$BORGDRONE_TARGET $BORGDRONE_STATUS $BORGDRONE_STEP $BORGDRONE_EXIT_CODE" > ` + out + ` && cat >> ` + out}
+	if err := n.Notify(context.Background(), failurePayload()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, payload, _ := strings.Cut(string(data), "\n")
+	if env != "laptop:usb failed prune 2" {
+		t.Errorf("unexpected environment %q", env)
+	}
+	if !strings.Contains(payload, `"target":"laptop:usb"`) {
+		t.Errorf("payload was not written to stdin: %q", payload)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	n := &EmailNotifier{Options: config.EmailOptions{Host: "smtp.example.com", From: "borgdrone@example.com", To: []string{"admin@example.com"}}}
+	msg := string(n.message(failurePayload()))
+	for _, want := range []string{
+		"To: admin@example.com\r\n",
+		"Subject: borgdrone: laptop:usb failed\r\n",
+		"Step:         prune\r\n",
+		"repository locked\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestEmailNotifierStalledServer(t *testing.T) {
+	// The server accepts connections but never sends its greeting
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	n := &EmailNotifier{Options: config.EmailOptions{Host: "127.0.0.1", Port: addr.Port, From: "borgdrone@example.com", To: []string{"admin@example.com"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- n.Notify(ctx, failurePayload()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error from a stalled server")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify did not return once ctx expired")
+	}
+}
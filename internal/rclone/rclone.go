@@ -0,0 +1,57 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"codeberg.org/jstover/borgdrone/internal/logger"
+	"codeberg.org/jstover/borgdrone/internal/stream"
+)
+
+// ErrRcloneNotFound is returned when the rclone executable cannot be found in $PATH
+var ErrRcloneNotFound = errors.New("rclone command was not found or is not installed")
+
+// ExitError is returned when rclone exits with a non-zero status
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("rclone failed (exit code %d)", e.Code)
+}
+
+type Runner struct {
+	Env []string
+	// LogPrefix is prepended to each line of rclone output
+	LogPrefix string
+}
+
+func (r *Runner) Run(ctx context.Context, args ...string) error {
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return ErrRcloneNotFound
+	}
+	command := stream.NewCmd("rclone", args...)
+	command.Env = r.Env
+
+	onStdout := func(line string) {
+		logger.Debug("%s", logger.PrefixLine(r.LogPrefix, line))
+	}
+	onStderr := func(line string) {
+		logger.Debug("%s", logger.PrefixLine(r.LogPrefix, line))
+		fmt.Fprintln(os.Stderr, logger.PrefixLine(r.LogPrefix, line))
+	}
+	status, err := stream.Run(ctx, command, nil, onStdout, onStderr)
+	if err != nil {
+		return err
+	}
+	if status.Error != nil {
+		return status.Error
+	}
+	if status.Exit != 0 {
+		return &ExitError{Code: status.Exit}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Package schedule parses the `schedule:` value of a target, which is either
+// a cron expression or a fixed interval between runs
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next time a target should run after the previous run
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Interval runs a target a fixed duration after its previous run
+type Interval time.Duration
+
+func (i Interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// Cron is a standard 5 field cron expression: minute hour day-of-month month day-of-week
+type Cron struct {
+	Minute     uint64
+	Hour       uint64
+	DayOfMonth uint64
+	Month      uint64
+	DayOfWeek  uint64
+	// domStar and dowStar record an unrestricted field, as cron matches either day field when both are restricted
+	domStar bool
+	dowStar bool
+}
+
+// macros are the supported @ shorthands for common cron expressions
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse reads a schedule. Intervals are Go durations such as "6h" or "@every 6h".
+// Anything else is parsed as a cron expression or one of the @daily style macros
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		return parseInterval(strings.TrimSpace(every))
+	}
+	if _, err := time.ParseDuration(spec); err == nil {
+		return parseInterval(spec)
+	}
+	if expr, ok := macros[spec]; ok {
+		spec = expr
+	}
+	return parseCron(spec)
+}
+
+func parseInterval(spec string) (Schedule, error) {
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule interval '%s': %w", spec, err)
+	}
+	if d < time.Minute {
+		return nil, fmt.Errorf("schedule interval '%s' must be at least 1m", spec)
+	}
+	return Interval(d), nil
+}
+
+func parseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule '%s': expected a duration or 5 cron fields", spec)
+	}
+	c := Cron{}
+	var err error
+	if c.Minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule '%s': %w", spec, err)
+	}
+	if c.Hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule '%s': %w", spec, err)
+	}
+	if c.DayOfMonth, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month in schedule '%s': %w", spec, err)
+	}
+	if c.Month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule '%s': %w", spec, err)
+	}
+	if c.DayOfWeek, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week in schedule '%s': %w", spec, err)
+	}
+	// Both 0 and 7 are Sunday
+	if c.DayOfWeek&(1<<7) != 0 {
+		c.DayOfWeek |= 1
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return c, nil
+}
+
+// parseField converts a comma separated list of *, N, N-M, optionally followed by /STEP, into a bitset
+func parseField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			loPart, hiPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(loPart); err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", loPart)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiPart); err != nil {
+					return 0, fmt.Errorf("invalid value '%s'", hiPart)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.DayOfMonth&(1<<t.Day()) != 0
+	dow := c.DayOfWeek&(1<<int(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching minute strictly after the given time, in its location.
+// The zero time is returned if nothing matches within five years, such as for "0 0 30 2 *"
+func (c Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.Month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.Hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.Minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// weekdays are the day names used by systemd calendar events, indexed by cron day of week
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// bitList formats the set bits between min and max as a comma separated list, or * when every value is set
+func bitList(bits uint64, min int, max int, format func(int) string) string {
+	values := []string{}
+	for i := min; i <= max; i++ {
+		if bits&(1<<i) != 0 {
+			values = append(values, format(i))
+		}
+	}
+	if len(values) == max-min+1 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
+
+// OnCalendar converts the expression into systemd calendar events.
+// When both day fields are restricted cron matches either of them, which needs one event for each
+func (c Cron) OnCalendar() []string {
+	twoDigits := func(i int) string { return fmt.Sprintf("%02d", i) }
+	date := func(dom uint64) string {
+		return fmt.Sprintf("*-%s-%s", bitList(c.Month, 1, 12, twoDigits), bitList(dom, 1, 31, twoDigits))
+	}
+	clock := fmt.Sprintf("%s:%s:00", bitList(c.Hour, 0, 23, twoDigits), bitList(c.Minute, 0, 59, twoDigits))
+	days := bitList(c.DayOfWeek, 0, 6, func(i int) string { return weekdays[i] })
+	allDays := uint64(1<<32 - 2)
+
+	switch {
+	case c.dowStar:
+		return []string{date(c.DayOfMonth) + " " + clock}
+	case c.domStar:
+		return []string{days + " " + date(allDays) + " " + clock}
+	default:
+		return []string{
+			date(c.DayOfMonth) + " " + clock,
+			days + " " + date(allDays) + " " + clock,
+		}
+	}
+}
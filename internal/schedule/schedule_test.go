@@ -0,0 +1,73 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	base := time.Date(2024, time.March, 15, 10, 30, 0, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"6h", base.Add(6 * time.Hour)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+		{"@daily", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"45 * * * *", time.Date(2024, time.March, 15, 10, 45, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2024, time.March, 15, 10, 40, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, time.March, 16, 2, 0, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2024, time.March, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, time.March, 17, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are restricted
+		{"0 0 20 * 6", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC).AddDate(4, 0, 0)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "daily", "30s", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) expected an error", spec)
+		}
+	}
+}
+
+func TestOnCalendar(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"@daily", []string{"*-*-* 00:00:00"}},
+		{"*/15 * * * *", []string{"*-*-* *:00,15,30,45:00"}},
+		{"30 2 * * 1-5", []string{"Mon,Tue,Wed,Thu,Fri *-*-* 02:30:00"}},
+		{"0 4 1 1,7 *", []string{"*-01,07-01 04:00:00"}},
+		{"0 0 1 * 0", []string{"*-*-01 00:00:00", "Sun *-*-* 00:00:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.(Cron).OnCalendar(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OnCalendar() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Package stream runs external commands with go-cmd, handing each line of their output to a callback as it is produced
+package stream
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-cmd/cmd"
+)
+
+// Run starts command with stdin, calling stdout and stderr for each line written to them until the command exits.
+// If ctx is cancelled first the command is stopped and ctx.Err() is returned.
+// Otherwise the final status is returned, and callers check its Error and Exit fields
+func Run(ctx context.Context, command *cmd.Cmd, stdin io.Reader, stdout func(line string), stderr func(line string)) (cmd.Status, error) {
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		for command.Stdout != nil || command.Stderr != nil {
+			select {
+			case line, open := <-command.Stdout:
+				if !open {
+					command.Stdout = nil
+					continue
+				}
+				stdout(line)
+			case line, open := <-command.Stderr:
+				if !open {
+					command.Stderr = nil
+					continue
+				}
+				stderr(line)
+			}
+		}
+	}()
+
+	statusChan := command.StartWithStdin(stdin)
+	select {
+	case <-statusChan:
+	case <-ctx.Done():
+		command.Stop()
+		<-statusChan
+		<-doneChan
+		return command.Status(), ctx.Err()
+	}
+	<-doneChan
+	return command.Status(), nil
+}
+
+// NewCmd returns a command which streams its output line by line without buffering it, for use with Run
+func NewCmd(name string, args ...string) *cmd.Cmd {
+	return cmd.NewCmdOptions(cmd.Options{Buffered: false, Streaming: true}, name, args...)
+}
@@ -0,0 +1,43 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	command := NewCmd("sh", "-c", "cat; echo out; echo err >&2; exit 3")
+	stdout, stderr := []string{}, []string{}
+	status, err := Run(context.Background(), command, strings.NewReader("in\n"),
+		func(line string) { stdout = append(stdout, line) },
+		func(line string) { stderr = append(stderr, line) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Exit != 3 {
+		t.Errorf("exit code = %d, want 3", status.Exit)
+	}
+	if want := []string{"in", "out"}; !slices.Equal(stdout, want) {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	if want := []string{"err"}; !slices.Equal(stderr, want) {
+		t.Errorf("stderr = %q, want %q", stderr, want)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Run(ctx, NewCmd("sleep", "10"), nil, func(string) {}, func(string) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command was not stopped, ran for %s", elapsed)
+	}
+}